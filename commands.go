@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd-plugin-cache/plugin"
+	"github.com/hashicorp/go-hclog"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/cast"
+)
+
+const commandsUsage = `Usage: gatewayd-plugin-cache [--log-level <level>] <command> [flags]
+
+Commands run against the Redis server set in REDIS_URL, outside GatewayD:
+  stats          Show key counts, bytes and a TTL histogram per database and table
+  invalidate     Invalidate the cached responses of a table (--table), a database (--database) or a tag (--tag)
+  dump           Decode the cached response of a query (--server, --database, --sql) into a table
+  purge-orphans  Delete table and tag index keys whose cached response does not exist anymore
+  explain        Explain how a query (--server, --database, --sql) is cached and why
+  export         Export the cached responses to a snapshot file (--output), optionally by --database or --table
+  import         Import the cached responses of a snapshot file (--input), optionally by --database or --table,
+                 rebasing their TTL (--ttl keep|elapsed|expiry)
+`
+
+// newCommandPlugin returns a plugin connected to the configured Redis server,
+// for running commands outside GatewayD.
+func newCommandPlugin(logger hclog.Logger, cfg map[string]interface{}) (*plugin.Plugin, error) {
+	redisURL := cast.ToString(cfg["redisURL"])
+	if redisURL == "" {
+		redisURL = "redis://localhost:6379"
+	}
+
+	redisConfig, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
+	}
+
+	serverGroups, err := plugin.ParseServerGroups(cast.ToString(cfg["serverGroups"]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse server groups: %w", err)
+	}
+
+	expiry := cast.ToDuration(cfg["expiry"])
+	if expiry <= 0 {
+		expiry = time.Hour
+	}
+
+	scanCount := cast.ToInt64(cfg["scanCount"])
+	if scanCount <= 0 {
+		scanCount = 1000
+	}
+
+	redisClient := redis.NewClient(redisConfig)
+	if err := redisClient.Ping(context.Background()).Err(); err != nil {
+		redisClient.Close()
+		return nil, fmt.Errorf("failed to ping Redis server: %w", err)
+	}
+
+	return &plugin.Plugin{
+		Logger:              logger,
+		RedisClient:         redisClient,
+		RedisURL:            redisURL,
+		Expiry:              expiry,
+		DefaultDBName:       cast.ToString(cfg["defaultDBName"]),
+		ScanCount:           scanCount,
+		KeyPrefix:           cast.ToString(cfg["keyPrefix"]),
+		NormalizedCacheKeys: cast.ToBool(cfg["normalizedCacheKeys"]),
+		ServerGroups:        serverGroups,
+	}, nil
+}
+
+// runCommand runs a cache operation command and returns the exit code.
+func runCommand(logger hclog.Logger, args []string, stdout, stderr io.Writer) int {
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Fprint(stdout, commandsUsage)
+		return 0
+	}
+
+	commands := map[string]func(context.Context, *plugin.Plugin, []string, io.Writer) error{
+		"stats":         statsCommand,
+		"invalidate":    invalidateCommand,
+		"dump":          dumpCommand,
+		"purge-orphans": purgeOrphansCommand,
+		"explain":       explainCommand,
+		"export":        exportCommand,
+		"import":        importCommand,
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown command: %s\n\n%s", args[0], commandsUsage)
+		return 2
+	}
+
+	cachePlugin, err := newCommandPlugin(logger, cast.ToStringMap(plugin.PluginConfig["config"]))
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return 1
+	}
+	defer cachePlugin.RedisClient.Close()
+
+	if err := command(context.Background(), cachePlugin, args[1:], stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(stderr, "Error:", err)
+		}
+		return 1
+	}
+
+	return 0
+}
+
+func statsCommand(ctx context.Context, p *plugin.Plugin, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	stats, err := p.Stats(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Sessions: %d\n\n", stats.Sessions)
+	printKeyStats(stdout, "DATABASE", stats.Databases)
+	fmt.Fprintln(stdout)
+	printKeyStats(stdout, "TABLE", stats.Tables)
+	return nil
+}
+
+func printKeyStats(stdout io.Writer, title string, stats map[string]*plugin.KeyStats) {
+	labels := plugin.TTLBucketLabels()
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(writer, "%s\tKEYS\tBYTES\t%s\n", title, strings.Join(labels, "\t"))
+
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(writer, "%s\t%d\t%d", name, stats[name].Keys, stats[name].Bytes)
+		for _, label := range labels {
+			fmt.Fprintf(writer, "\t%d", stats[name].TTLHistogram[label])
+		}
+		fmt.Fprintln(writer)
+	}
+	writer.Flush()
+}
+
+func invalidateCommand(ctx context.Context, p *plugin.Plugin, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("invalidate", flag.ContinueOnError)
+	table := flags.String("table", "", "Invalidate the cached responses of the table")
+	database := flags.String("database", "", "Invalidate the cached responses of the database")
+	tag := flags.String("tag", "", "Invalidate the cached responses tagged with the tag")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var deleted int
+	var err error
+	set := 0
+	for _, value := range []string{*table, *database, *tag} {
+		if value != "" {
+			set++
+		}
+	}
+	switch {
+	case set == 0:
+		return errors.New("one of --table, --database or --tag is required")
+	case set > 1:
+		return errors.New("only one of --table, --database or --tag can be set")
+	case *table != "":
+		deleted = p.InvalidateTable(ctx, *table)
+	case *database != "":
+		deleted, err = p.InvalidateDatabase(ctx, *database)
+	default:
+		deleted, err = p.InvalidateTag(ctx, *tag)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Deleted %d keys\n", deleted)
+	return nil
+}
+
+func dumpCommand(ctx context.Context, p *plugin.Plugin, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("dump", flag.ContinueOnError)
+	server := flags.String("server", "", "The address of the database server, e.g. localhost:5432")
+	database := flags.String("database", p.DefaultDBName, "The name of the database")
+	sql := flags.String("sql", "", "The query whose cached response is dumped")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *server == "" || *database == "" || *sql == "" {
+		return errors.New("--server, --database and --sql are required")
+	}
+
+	response, err := p.CachedResponse(ctx, *server, *database, *sql)
+	if errors.Is(err, redis.Nil) {
+		return errors.New("the query is not cached")
+	} else if err != nil {
+		return err
+	}
+
+	result, err := plugin.DecodeResponse(response)
+	if err != nil {
+		return fmt.Errorf("failed to decode cached response: %w", err)
+	}
+
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(result.Columns, "\t"))
+	for _, row := range result.Rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	writer.Flush()
+
+	if result.Error != "" {
+		fmt.Fprintf(stdout, "Error: %s\n", result.Error)
+	}
+	fmt.Fprintf(stdout, "(%s)\n", result.CommandComplete)
+	return nil
+}
+
+func purgeOrphansCommand(ctx context.Context, p *plugin.Plugin, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("purge-orphans", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	deleted, err := p.PurgeOrphanedIndexKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Deleted %d orphaned table index keys\n", deleted)
+	return nil
+}
+
+func explainCommand(ctx context.Context, p *plugin.Plugin, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+	server := flags.String("server", "", "The address of the database server, e.g. localhost:5432")
+	database := flags.String("database", p.DefaultDBName, "The name of the database")
+	user := flags.String("user", "", "The user of the session")
+	sql := flags.String("sql", "", "The query to explain")
+	var settings []string
+	flags.Func("setting", "A setting of the session as name=value, can be repeated", func(value string) error {
+		settings = append(settings, value)
+		return nil
+	})
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *server == "" || *sql == "" {
+		return errors.New("--server and --sql are required")
+	}
+
+	sessionSettings, err := plugin.ParseSettings(settings)
+	if err != nil {
+		return err
+	}
+
+	explanation, err := p.Explain(ctx, plugin.ExplainRequest{
+		Server:   *server,
+		Database: *database,
+		User:     *user,
+		Settings: sessionSettings,
+		SQL:      *sql,
+	})
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(writer, "Cluster:\t%s\n", explanation.Cluster)
+	fmt.Fprintf(writer, "Database:\t%s\n", explanation.Database)
+	fmt.Fprintf(writer, "Cache key:\t%q\n", explanation.CacheKey)
+	fmt.Fprintf(writer, "Fingerprint:\t%s\n", explanation.Fingerprint)
+	fmt.Fprintf(writer, "Statements:\t%s\n", strings.Join(explanation.Statements, ", "))
+	fmt.Fprintf(writer, "Tables:\t%s\n", strings.Join(explanation.Tables, ", "))
+	fmt.Fprintf(writer, "Tags:\t%s\n", strings.Join(explanation.Tags, ", "))
+	fmt.Fprintf(writer, "Volatile functions:\t%s\n", strings.Join(explanation.VolatileFunctions, ", "))
+	fmt.Fprintf(writer, "Cacheable:\t%t\n", explanation.Cacheable)
+	fmt.Fprintf(writer, "Invalidates:\t%t\n", explanation.Invalidates)
+	fmt.Fprintf(writer, "TTL:\t%s\n", explanation.TTL)
+	if entry := explanation.Entry; entry != nil && entry.Hit {
+		fmt.Fprintf(writer, "Cached:\tyes, %d bytes, expires in %s\n", entry.Size, entry.TTL)
+	} else {
+		fmt.Fprintln(writer, "Cached:\tno")
+	}
+	writer.Flush()
+
+	fmt.Fprintln(stdout, "\nRules:")
+	for _, rule := range explanation.Rules {
+		fmt.Fprintf(stdout, "  %s: %s\n", rule.Name, rule.Detail)
+	}
+	return nil
+}
+
+func exportCommand(ctx context.Context, p *plugin.Plugin, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("output", "-", "The snapshot file, or - for the standard output")
+	database := flags.String("database", "", "Only export the cached responses of the database")
+	table := flags.String("table", "", "Only export the cached responses of the table")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter := plugin.SnapshotFilter{Database: *database, Table: *table}
+	if *output == "-" {
+		_, err := p.ExportSnapshot(ctx, stdout, filter)
+		return err
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+
+	exported, err := p.ExportSnapshot(ctx, file, filter)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Exported %d cached responses to %s\n", exported, *output)
+	return nil
+}
+
+func importCommand(ctx context.Context, p *plugin.Plugin, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	input := flags.String("input", "-", "The snapshot file, or - for the standard input")
+	database := flags.String("database", "", "Only import the cached responses of the database")
+	table := flags.String("table", "", "Only import the cached responses of the table")
+	ttl := flags.String("ttl", string(plugin.TTLRebaseKeep),
+		"The TTL of the imported responses: keep the exported TTL, subtract the time "+
+			"elapsed since the export, or reset it to the expiry")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	rebase, err := plugin.ParseTTLRebase(*ttl)
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader = os.Stdin
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	imported, err := p.ImportSnapshot(
+		ctx, reader, plugin.SnapshotFilter{Database: *database, Table: *table}, rebase)
+	if err != nil {
+		return fmt.Errorf("imported %d cached responses before failing: %w", imported, err)
+	}
+
+	fmt.Fprintf(stdout, "Imported %d cached responses\n", imported)
+	return nil
+}
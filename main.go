@@ -3,10 +3,13 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 
 	"github.com/gatewayd-io/gatewayd-plugin-cache/plugin"
 	sdkConfig "github.com/gatewayd-io/gatewayd-plugin-sdk/config"
@@ -18,8 +21,10 @@ import (
 	"github.com/getsentry/sentry-go"
 	"github.com/hashicorp/go-hclog"
 	goplugin "github.com/hashicorp/go-plugin"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/redis/go-redis/v9"
 	"github.com/spf13/cast"
+	sdkTrace "go.opentelemetry.io/otel/sdk/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -41,6 +46,22 @@ func handleStartupError(
 	}
 }
 
+// loadWarmupEntries reads the queries of the warm-up from the file.
+func loadWarmupEntries(path string) ([]plugin.WarmupEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries, err := plugin.ReadWarmupEntries(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return entries, nil
+}
+
 func main() {
 	sentryDSN := sdkConfig.GetEnv("SENTRY_DSN", "")
 	err := sentry.Init(sentry.ClientOptions{
@@ -52,6 +73,10 @@ func main() {
 	}
 
 	logLevel := flag.String("log-level", "info", "Log level")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), commandsUsage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	logger := hclog.New(&hclog.LoggerOptions{
@@ -61,11 +86,22 @@ func main() {
 		Color:      hclog.ColorOff,
 	})
 
+	// Run a cache operation command instead of serving the plugin, if one is given.
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(logger, flag.Args(), os.Stdout, os.Stderr))
+	}
+
 	pluginInstance := plugin.NewCachePlugin(plugin.Plugin{
 		Logger:    logger,
 		WaitGroup: &sync.WaitGroup{},
 	})
 
+	// The root context of the background work, which is cancelled on shutdown.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	shutdownTimeout := cast.ToDuration(plugin.DefaultShutdownTimeout)
+	var tracerProvider *sdkTrace.TracerProvider
+
 	//nolint:nestif
 	if cfg := cast.ToStringMap(plugin.PluginConfig["config"]); cfg != nil {
 		pluginInstance.Impl.ExitOnStartupError = cast.ToBool(cfg["exitOnStartupError"])
@@ -82,7 +118,14 @@ func main() {
 			pluginInstance.Impl.Expiry = cast.ToDuration("1h")
 		}
 
+		pluginInstance.Impl.EmptyResultExpiry = cast.ToDuration(cfg["emptyResultExpiry"])
+		if pluginInstance.Impl.EmptyResultExpiry < 0 {
+			logger.Warn("emptyResultExpiry is invalid, disabling caching of empty result sets")
+			pluginInstance.Impl.EmptyResultExpiry = 0
+		}
+
 		pluginInstance.Impl.DefaultDBName = cast.ToString(cfg["defaultDBName"])
+		pluginInstance.Impl.SessionBackup = cast.ToBool(cfg["sessionBackupEnabled"])
 
 		pluginInstance.Impl.ScanCount = cast.ToInt64(cfg["scanCount"])
 		if pluginInstance.Impl.ScanCount <= 0 {
@@ -90,11 +133,70 @@ func main() {
 			pluginInstance.Impl.ScanCount = 1000
 		}
 
+		pluginInstance.Impl.KeyPrefix = cast.ToString(cfg["keyPrefix"])
+		if pluginInstance.Impl.KeyPrefix == "" {
+			logger.Warn("keyPrefix is empty, defaulting to " + plugin.DefaultKeyPrefix)
+			pluginInstance.Impl.KeyPrefix = plugin.DefaultKeyPrefix
+		}
+
+		pluginInstance.Impl.NormalizedCacheKeys = cast.ToBool(cfg["normalizedCacheKeys"])
+
+		pluginInstance.Impl.ShadowMode = cast.ToBool(cfg["shadowMode"])
+		if pluginInstance.Impl.ShadowMode {
+			logger.Info("Shadow mode is enabled, cached responses are compared but never served")
+			pluginInstance.Impl.StaleResponses = plugin.NewStaleResponses(plugin.DefaultStaleResponsesCapacity)
+		}
+
+		pluginInstance.Impl.VerificationSampleRate = cast.ToFloat64(cfg["verificationSampleRate"])
+		if rate := pluginInstance.Impl.VerificationSampleRate; rate < 0 || rate > 1 {
+			logger.Warn("verificationSampleRate is invalid, cached responses are not verified")
+			pluginInstance.Impl.VerificationSampleRate = 0
+		}
+
+		serverGroups, err := plugin.ParseServerGroups(cast.ToString(cfg["serverGroups"]))
+		if err != nil {
+			handleStartupError(
+				logger, pluginInstance.Impl.ExitOnStartupError,
+				"Failed to parse server groups", err)
+		}
+		pluginInstance.Impl.ServerGroups = serverGroups
+
+		pluginInstance.Impl.MetricsLabelLimit = cast.ToInt(cfg["metricsLabelLimit"])
+		if pluginInstance.Impl.MetricsLabelLimit <= 0 {
+			logger.Warn("metricsLabelLimit is invalid or unset, defaulting to 100")
+			pluginInstance.Impl.MetricsLabelLimit = plugin.DefaultMetricsLabelLimit
+		}
+		pluginInstance.Impl.MetricsTableLabels = cast.ToBool(cfg["metricsTableLabels"])
+
+		// The top queries are not tracked if the capacity is zero.
+		if topQueriesCapacity := cast.ToInt(cfg["topQueriesCapacity"]); topQueriesCapacity > 0 {
+			pluginInstance.Impl.TopQueries = plugin.NewTopQueries(topQueriesCapacity)
+		} else if topQueriesCapacity < 0 {
+			logger.Warn("topQueriesCapacity is invalid, top queries are not tracked")
+		}
+
 		metricsConfig := metrics.NewMetricsConfig(cfg)
-		if metricsConfig != nil && metricsConfig.Enabled {
+		metricsEnabled := metricsConfig != nil && metricsConfig.Enabled
+		if metricsEnabled {
 			go metrics.ExposeMetrics(metricsConfig, logger)
 		}
 
+		if cast.ToBool(cfg["tracingEnabled"]) {
+			tracerProvider, err = plugin.NewTracerProvider(
+				ctx,
+				cast.ToString(cfg["tracingOTLPEndpoint"]),
+				cast.ToBool(cfg["tracingOTLPInsecure"]),
+				cast.ToFloat64(cfg["tracingSampleRatio"]),
+			)
+			if err != nil {
+				handleStartupError(
+					logger, pluginInstance.Impl.ExitOnStartupError,
+					"Failed to initialize tracing", err)
+			} else {
+				pluginInstance.Impl.Tracer = tracerProvider.Tracer(plugin.PluginID.GetName())
+			}
+		}
+
 		apiGRPCAddress := cast.ToString(cfg["apiGRPCAddress"])
 		apiClientConn, err := grpc.NewClient(
 			apiGRPCAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
@@ -111,9 +213,31 @@ func main() {
 			cacheBufferSize = 100
 		}
 
+		shutdownTimeout = cast.ToDuration(cfg["shutdownTimeout"])
+		if shutdownTimeout <= 0 {
+			logger.Warn("shutdownTimeout is invalid or unset, defaulting to " + plugin.DefaultShutdownTimeout)
+			shutdownTimeout = cast.ToDuration(plugin.DefaultShutdownTimeout)
+		}
+
+		pluginInstance.Impl.CacheWriters = cast.ToInt(cfg["cacheWriters"])
+		if pluginInstance.Impl.CacheWriters <= 0 {
+			logger.Warn("cacheWriters is invalid or unset, defaulting to 4")
+			pluginInstance.Impl.CacheWriters = plugin.DefaultCacheWriters
+		}
+
+		overflowPolicy, err := plugin.ParseOverflowPolicy(cast.ToString(cfg["cacheOverflowPolicy"]))
+		if err != nil {
+			handleStartupError(
+				logger, pluginInstance.Impl.ExitOnStartupError,
+				"Failed to parse cache overflow policy, defaulting to "+string(plugin.DefaultOverflowPolicy),
+				err, apiClientConn)
+			overflowPolicy = plugin.DefaultOverflowPolicy
+		}
+		pluginInstance.Impl.OverflowPolicy = overflowPolicy
+
 		pluginInstance.Impl.UpdateCacheChannel = make(chan *v1.Struct, cacheBufferSize)
 		pluginInstance.Impl.WaitGroup.Add(1)
-		go pluginInstance.Impl.UpdateCache(context.Background())
+		go pluginInstance.Impl.UpdateCache(ctx)
 
 		redisConfig, err := redis.ParseURL(pluginInstance.Impl.RedisURL)
 		if err != nil {
@@ -124,13 +248,40 @@ func main() {
 
 		pluginInstance.Impl.RedisClient = redis.NewClient(redisConfig)
 
-		_, err = pluginInstance.Impl.RedisClient.Ping(context.Background()).Result()
+		_, err = pluginInstance.Impl.RedisClient.Ping(ctx).Result()
 		if err != nil {
 			handleStartupError(
 				logger, pluginInstance.Impl.ExitOnStartupError,
 				"Failed to ping Redis server", err, apiClientConn)
 		}
 
+		if cast.ToBool(cfg["circuitBreakerEnabled"]) {
+			openDuration := cast.ToDuration(cfg["circuitBreakerOpenDuration"])
+			if openDuration <= 0 {
+				logger.Warn("circuitBreakerOpenDuration is invalid or unset, defaulting to 10s")
+				openDuration = cast.ToDuration("10s")
+			}
+
+			pluginInstance.Impl.CircuitBreaker = plugin.NewCircuitBreaker(
+				cast.ToInt(cfg["circuitBreakerFailureThreshold"]),
+				openDuration,
+				cast.ToInt(cfg["circuitBreakerHalfOpenProbes"]),
+				logger,
+			)
+			pluginInstance.Impl.RedisClient.AddHook(pluginInstance.Impl.CircuitBreaker)
+			pluginInstance.Impl.WaitGroup.Add(1)
+			go pluginInstance.Impl.RecoverMissedInvalidations(ctx)
+		}
+
+		// Commands rejected by the circuit breaker are not observed.
+		if metricsEnabled {
+			pluginInstance.Impl.RedisClient.AddHook(plugin.RedisMetricsHook{})
+		}
+		if pluginInstance.Impl.Tracer != nil {
+			pluginInstance.Impl.RedisClient.AddHook(
+				plugin.RedisTracingHook{Tracer: pluginInstance.Impl.Tracer})
+		}
+
 		pluginInstance.Impl.PeriodicInvalidatorEnabled = cast.ToBool(
 			cfg["periodicInvalidatorEnabled"])
 		pluginInstance.Impl.PeriodicInvalidatorStartDelay = cast.ToDuration(
@@ -139,15 +290,119 @@ func main() {
 			cfg["periodicInvalidatorInterval"])
 
 		if pluginInstance.Impl.PeriodicInvalidatorEnabled {
-			pluginInstance.Impl.PeriodicInvalidator()
+			pluginInstance.Impl.PeriodicInvalidator(ctx)
+		}
+
+		warmupFile := cast.ToString(cfg["warmupFile"])
+		refreshAheadEnabled := cast.ToBool(cfg["refreshAheadEnabled"])
+		if warmupFile != "" || refreshAheadEnabled {
+			postgresConfig, err := pgconn.ParseConfig(cast.ToString(cfg["postgresDSN"]))
+			if err != nil {
+				handleStartupError(
+					logger, pluginInstance.Impl.ExitOnStartupError,
+					"Failed to parse Postgres DSN", err, apiClientConn)
+			} else {
+				pluginInstance.Impl.PostgresConfig = postgresConfig
+			}
+		}
+
+		if warmupFile != "" && pluginInstance.Impl.PostgresConfig != nil {
+			entries, err := loadWarmupEntries(warmupFile)
+			if err != nil {
+				handleStartupError(
+					logger, pluginInstance.Impl.ExitOnStartupError,
+					"Failed to load warm-up queries", err, apiClientConn)
+			} else {
+				warmupConcurrency := cast.ToInt(cfg["warmupConcurrency"])
+				if warmupConcurrency <= 0 {
+					logger.Warn("warmupConcurrency is invalid or unset, defaulting to 4")
+					warmupConcurrency = plugin.DefaultWarmupConcurrency
+				}
+				go pluginInstance.Impl.WarmUp(ctx, entries, warmupConcurrency)
+			}
+		}
+
+		if refreshAheadEnabled && pluginInstance.Impl.PostgresConfig != nil {
+			pluginInstance.Impl.RefreshAheadMinHits = cast.ToInt64(cfg["refreshAheadMinHits"])
+			if pluginInstance.Impl.RefreshAheadMinHits <= 0 {
+				logger.Warn("refreshAheadMinHits is invalid or unset, defaulting to 10")
+				pluginInstance.Impl.RefreshAheadMinHits = plugin.DefaultRefreshAheadMinHits
+			}
+
+			pluginInstance.Impl.RefreshAheadFraction = cast.ToFloat64(cfg["refreshAheadFraction"])
+			if fraction := pluginInstance.Impl.RefreshAheadFraction; fraction <= 0 || fraction >= 1 {
+				logger.Warn("refreshAheadFraction is invalid or unset, defaulting to 0.1")
+				pluginInstance.Impl.RefreshAheadFraction = plugin.DefaultRefreshAheadFraction
+			}
+
+			pluginInstance.Impl.RefreshAheadBudget = cast.ToInt(cfg["refreshAheadBudget"])
+			if pluginInstance.Impl.RefreshAheadBudget <= 0 {
+				logger.Warn("refreshAheadBudget is invalid or unset, defaulting to 10")
+				pluginInstance.Impl.RefreshAheadBudget = plugin.DefaultRefreshAheadBudget
+			}
+
+			refreshAheadInterval := cast.ToDuration(cfg["refreshAheadInterval"])
+			if refreshAheadInterval <= 0 {
+				logger.Warn("refreshAheadInterval is invalid or unset, defaulting to 10s")
+				refreshAheadInterval = cast.ToDuration("10s")
+			}
+
+			pluginInstance.Impl.RefreshAhead(ctx, refreshAheadInterval)
+		}
+
+		// The size of the cache is not sampled if the interval is zero.
+		if sampleInterval := cast.ToDuration(cfg["metricsSampleInterval"]); metricsEnabled && sampleInterval > 0 {
+			pluginInstance.Impl.CacheSizeSampler(ctx, sampleInterval)
+
+			topQueriesMetrics := cast.ToInt(cfg["topQueriesMetrics"])
+			if topQueriesMetrics <= 0 {
+				logger.Warn("topQueriesMetrics is invalid or unset, defaulting to 10")
+				topQueriesMetrics = plugin.DefaultTopQueriesMetrics
+			}
+			pluginInstance.Impl.TopQueriesSampler(sampleInterval, topQueriesMetrics)
+		}
+
+		if cast.ToBool(cfg["adminEnabled"]) {
+			go pluginInstance.Impl.ExposeAdminAPI(cast.ToString(cfg["adminUnixDomainSocket"]))
 		}
 	}
 
-	defer func() {
-		if pluginInstance.Impl.UpdateCacheChannel != nil {
-			close(pluginInstance.Impl.UpdateCacheChannel)
-			pluginInstance.Impl.WaitGroup.Wait()
+	// Stop the background work, drain the queued server responses until the
+	// timeout and close the Redis client, either when GatewayD stops the plugin
+	// or when the plugin receives SIGTERM. SIGINT is ignored by go-plugin,
+	// as it is handled by GatewayD.
+	shutdown := sync.OnceFunc(func() {
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancelDrain()
+		if err := pluginInstance.Impl.Shutdown(drainCtx); err != nil {
+			logger.Warn("Failed to shut down gracefully", "error", err)
 		}
+
+		// Export the remaining spans, including the ones of the drained responses.
+		if tracerProvider != nil {
+			if err := tracerProvider.Shutdown(drainCtx); err != nil {
+				logger.Debug("Failed to shut down tracing", "error", err)
+			}
+		}
+
+		// Abort any Redis command that is still running.
+		cancel()
+		if pluginInstance.Impl.RedisClient != nil {
+			if err := pluginInstance.Impl.RedisClient.Close(); err != nil {
+				logger.Debug("Failed to close Redis client", "error", err)
+			}
+		}
+		logger.Info("Plugin shut down")
+	})
+	defer shutdown()
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM)
+		<-signals
+		logger.Info("Received SIGTERM, shutting down")
+		shutdown()
+		os.Exit(0)
 	}()
 
 	goplugin.Serve(&goplugin.ServeConfig{
@@ -0,0 +1,327 @@
+package plugin
+
+import (
+	"container/heap"
+	"context"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd-plugin-sdk/databases/postgres"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+const (
+	// FingerprintLength is the length of a hex encoded query fingerprint.
+	FingerprintLength = 64
+	// keyMissingTTL is returned by PTTL if the key does not exist.
+	keyMissingTTL = time.Duration(-2)
+)
+
+// CacheEntry describes a cached response.
+type CacheEntry struct {
+	Cluster     string `json:"cluster"`
+	Database    string `json:"database"`
+	Query       string `json:"query,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Size        int64  `json:"size"`
+	TTL         string `json:"ttl"`
+}
+
+// LookupResult is the result of looking up a query in the cache.
+type LookupResult struct {
+	Hit  bool   `json:"hit"`
+	Key  string `json:"key"`
+	Size int64  `json:"size,omitempty"`
+	TTL  string `json:"ttl,omitempty"`
+}
+
+// scanKeys iterates over all the keys matching the pattern and calls fn
+// with each batch of keys returned by SCAN.
+func (p *Plugin) scanKeys(ctx context.Context, pattern string, fn func(keys []string)) error {
+	var cursor uint64
+	for {
+		scanResult := p.RedisClient.Scan(ctx, cursor, pattern, p.ScanCount)
+		if scanResult.Err() != nil {
+			CacheErrorsCounter.Inc()
+			return scanResult.Err()
+		}
+		CacheScanCounter.Inc()
+
+		var keys []string
+		keys, cursor = scanResult.Val()
+		CacheScanKeysCounter.Add(float64(len(keys)))
+		if len(keys) > 0 {
+			fn(keys)
+		}
+
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// invalidateMatching deletes the cached responses and the table and tag index keys
+// whose cache key matches, and returns the number of deleted keys.
+func (p *Plugin) invalidateMatching(
+	ctx context.Context, match func(cluster, database, request string) bool,
+) (deleted int, err error) {
+	ctx, span := p.startSpan(ctx, "cache.invalidate")
+	defer func() {
+		span.SetAttributes(CacheDeletedAttribute.Int(deleted))
+		endSpan(span, err)
+	}()
+
+	matchCacheKey := func(cacheKey string) bool {
+		cluster, database, request, ok := parseCacheKey(cacheKey)
+		return ok && match(cluster, database, request)
+	}
+
+	// The index keys are deleted in a transaction with their cached responses,
+	// just like they are written, so a response never outlives its index keys.
+	err = p.scanKeys(ctx, p.namespace(TableIndexNamespace)+"*", func(keys []string) {
+		pipeline := p.RedisClient.TxPipeline()
+		invalidated := make([][]string, 0, len(keys))
+		for _, indexKey := range keys {
+			if table, cacheKey, ok := p.parseTableIndexKey(indexKey); ok && matchCacheKey(cacheKey) {
+				pipeline.Del(ctx, p.responseKey(cacheKey))
+				pipeline.Del(ctx, indexKey)
+
+				_, database, _, _ := parseCacheKey(cacheKey)
+				invalidated = append(invalidated, p.metricLabels(database, table))
+			}
+		}
+		count, _ := p.execDeletePipeline(ctx, pipeline)
+		deleted += count
+		for _, labels := range invalidated {
+			CacheTableInvalidationsCounter.WithLabelValues(labels...).Inc()
+		}
+	})
+	if err != nil {
+		return deleted, err
+	}
+
+	err = p.scanKeys(ctx, p.namespace(TagIndexNamespace)+"*", func(keys []string) {
+		pipeline := p.RedisClient.TxPipeline()
+		for _, indexKey := range keys {
+			if _, cacheKey, ok := p.parseTagIndexKey(indexKey); ok && matchCacheKey(cacheKey) {
+				pipeline.Del(ctx, p.responseKey(cacheKey))
+				pipeline.Del(ctx, indexKey)
+			}
+		}
+		count, _ := p.execDeletePipeline(ctx, pipeline)
+		deleted += count
+	})
+	if err != nil {
+		return deleted, err
+	}
+
+	// Responses of queries whose tables could not be detected have no index key.
+	err = p.scanKeys(ctx, p.responseKey("*"), func(keys []string) {
+		pipeline := p.RedisClient.Pipeline()
+		for _, responseKey := range keys {
+			if matchCacheKey(strings.TrimPrefix(responseKey, p.responseKey(""))) {
+				pipeline.Del(ctx, responseKey)
+			}
+		}
+		count, _ := p.execDeletePipeline(ctx, pipeline)
+		deleted += count
+	})
+
+	return deleted, err
+}
+
+// InvalidateTable deletes all the cached responses that depend on the table.
+// This is the same code path that is used for invalidating the cache on DML.
+func (p *Plugin) InvalidateTable(ctx context.Context, table string) int {
+	return p.invalidateTables(ctx, []string{table})
+}
+
+// InvalidateDatabase deletes all the cached responses of the database.
+func (p *Plugin) InvalidateDatabase(ctx context.Context, database string) (int, error) {
+	return p.invalidateMatching(ctx, func(_, db, _ string) bool {
+		return db == database
+	})
+}
+
+// InvalidateFingerprint deletes all the cached responses of queries with the fingerprint.
+func (p *Plugin) InvalidateFingerprint(ctx context.Context, fingerprint string) (int, error) {
+	if !isFingerprint(fingerprint) {
+		return 0, ErrInvalidFingerprint
+	}
+
+	return p.invalidateMatching(ctx, func(_, _, request string) bool {
+		return requestFingerprint(request) == fingerprint
+	})
+}
+
+// Flush deletes all the cached responses and table index keys. Session keys are
+// kept, otherwise connected clients could not be served from the cache until
+// they reconnect.
+func (p *Plugin) Flush(ctx context.Context) (int, error) {
+	return p.invalidateMatching(ctx, func(_, _, _ string) bool {
+		return true
+	})
+}
+
+// Lookup checks whether the query would be served from the cache if it were sent
+// by a client connected to the database on the server.
+func (p *Plugin) Lookup(ctx context.Context, server, database, query string) (*LookupResult, error) {
+	request, err := (&pgproto3.Query{String: query}).Encode(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey := p.getCacheKey(server, database, request)
+	responseKey := p.responseKey(cacheKey)
+
+	pipeline := p.RedisClient.Pipeline()
+	size := pipeline.StrLen(ctx, responseKey)
+	ttl := pipeline.PTTL(ctx, responseKey)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		CacheErrorsCounter.Inc()
+		return nil, err
+	}
+	CacheGetsCounter.Inc()
+
+	result := &LookupResult{Key: responseKey}
+	if ttl.Val() != keyMissingTTL {
+		result.Hit = true
+		result.Size = size.Val()
+		result.TTL = formatTTL(ttl.Val())
+	}
+
+	return result, nil
+}
+
+// CachedResponse returns the cached response of the query if it were sent by
+// a client connected to the database on the server.
+func (p *Plugin) CachedResponse(ctx context.Context, server, database, query string) ([]byte, error) {
+	request, err := (&pgproto3.Query{String: query}).Encode(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := p.RedisClient.Get(ctx, p.responseKey(p.getCacheKey(server, database, request))).Bytes()
+	CacheGetsCounter.Inc()
+	return response, err
+}
+
+// TopEntries returns up to limit cached responses, largest first.
+func (p *Plugin) TopEntries(ctx context.Context, limit int) ([]CacheEntry, error) {
+	largest := &entryHeap{}
+	err := p.scanKeys(ctx, p.responseKey("*"), func(keys []string) {
+		sizes, ttls, err := p.sizesAndTTLs(ctx, keys)
+		if err != nil {
+			p.Logger.Debug("Failed to get the size of cached responses", "error", err)
+			return
+		}
+
+		for i, key := range keys {
+			heap.Push(largest, entryKey{key: key, size: sizes[i], ttl: ttls[i]})
+			if largest.Len() > limit {
+				heap.Pop(largest)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]CacheEntry, largest.Len())
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry, _ := heap.Pop(largest).(entryKey)
+		cluster, database, request, _ := parseCacheKey(strings.TrimPrefix(entry.key, p.responseKey("")))
+		query, fingerprint := describeRequest(request)
+		entries[i] = CacheEntry{
+			Cluster:     cluster,
+			Database:    database,
+			Query:       query,
+			Fingerprint: fingerprint,
+			Size:        entry.size,
+			TTL:         formatTTL(entry.ttl),
+		}
+	}
+
+	return entries, nil
+}
+
+// isFingerprint checks if the request part of a cache key is a query fingerprint.
+func isFingerprint(request string) bool {
+	if len(request) != FingerprintLength {
+		return false
+	}
+
+	_, err := hex.DecodeString(request)
+	return err == nil
+}
+
+// requestFingerprint returns the fingerprint of the request part of a cache key,
+// whether the key was created with normalized cache keys or not.
+func requestFingerprint(request string) string {
+	if isFingerprint(request) {
+		return request
+	}
+
+	fingerprint, err := fingerprintRequest([]byte(request))
+	if err != nil {
+		return ""
+	}
+
+	return fingerprint
+}
+
+// describeRequest returns the query and the fingerprint of the request part of
+// a cache key. The query is unknown if the key was created with normalized cache keys.
+func describeRequest(request string) (string, string) {
+	if isFingerprint(request) {
+		return "", request
+	}
+
+	if len(request) < postgres.MinPgSQLMessageLength || request[0] != QueryMessageType {
+		return "", ""
+	}
+
+	query, err := postgres.GetQueryFromRequest([]byte(request))
+	if err != nil {
+		return "", ""
+	}
+
+	fingerprint, _ := fingerprintQuery(query)
+	return query, fingerprint
+}
+
+// formatTTL formats the TTL of a key, as returned by PTTL.
+func formatTTL(ttl time.Duration) string {
+	if ttl < 0 {
+		return "none"
+	}
+
+	return ttl.Round(time.Second).String()
+}
+
+type entryKey struct {
+	key  string
+	size int64
+	ttl  time.Duration
+}
+
+// entryHeap is a min-heap of cached responses ordered by size.
+type entryHeap []entryKey
+
+func (h entryHeap) Len() int           { return len(h) }
+func (h entryHeap) Less(i, j int) bool { return h[i].size < h[j].size }
+func (h entryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *entryHeap) Push(x any) {
+	if entry, ok := x.(entryKey); ok {
+		*h = append(*h, entry)
+	}
+}
+
+func (h *entryHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
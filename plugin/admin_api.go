@@ -0,0 +1,275 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultTopEntriesLimit = 10
+	AdminReadHeaderTimeout = 5 * time.Second
+)
+
+// AdminHandler returns the HTTP handler of the admin API, which is used by
+// operators for inspecting and invalidating the cache.
+//
+//	POST /invalidate?table=<table>
+//	POST /invalidate?database=<database>
+//	POST /invalidate?fingerprint=<fingerprint>
+//	POST /invalidate?tag=<tag>
+//	POST /flush
+//	GET  /lookup?server=<address>&database=<database>&sql=<query>
+//	GET  /entries?limit=<count>
+//	GET  /top-queries?limit=<count>[&sort=lookups|hits|misses|bytes]
+//	GET  /stale-responses
+//	GET  /explain?server=<address>&sql=<query>[&database=<database>][&user=<user>]
+//	             [&session=<session ID>][&setting=<name>=<value>...]
+func (p *Plugin) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /invalidate", p.handleInvalidate)
+	mux.HandleFunc("POST /flush", p.handleFlush)
+	mux.HandleFunc("GET /lookup", p.handleLookup)
+	mux.HandleFunc("GET /entries", p.handleEntries)
+	mux.HandleFunc("GET /explain", p.handleExplain)
+	mux.HandleFunc("GET /top-queries", p.handleTopQueries)
+	mux.HandleFunc("GET /stale-responses", p.handleStaleResponses)
+	return mux
+}
+
+// ExposeAdminAPI exposes the admin API via HTTP over Unix domain socket.
+func (p *Plugin) ExposeAdminAPI(unixDomainSocket string) {
+	p.Logger.Info(
+		"Starting admin API server via HTTP over Unix domain socket",
+		"unixDomainSocket", unixDomainSocket)
+
+	if file, err := os.Stat(unixDomainSocket); err == nil &&
+		!file.IsDir() && file.Mode().Type() == os.ModeSocket {
+		if err := os.Remove(unixDomainSocket); err != nil {
+			p.Logger.Error("Failed to remove unix domain socket", "error", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", unixDomainSocket)
+	if err != nil {
+		p.Logger.Error("Failed to start admin API server", "error", err)
+		return
+	}
+
+	server := &http.Server{
+		Handler:           p.AdminHandler(),
+		ReadHeaderTimeout: AdminReadHeaderTimeout,
+	}
+	if !p.setAdminServer(server) {
+		listener.Close()
+		return
+	}
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		p.Logger.Error("Failed to start admin API server", "error", err)
+	}
+}
+
+// setAdminServer sets the server of the admin API, so that Shutdown stops it.
+// It returns false if Shutdown was already called.
+func (p *Plugin) setAdminServer(server *http.Server) bool {
+	if p.adminMutex == nil {
+		return true
+	}
+
+	p.adminMutex.Lock()
+	defer p.adminMutex.Unlock()
+	select {
+	case <-p.stopping:
+		return false
+	default:
+	}
+	p.adminServer = server
+	return true
+}
+
+func (p *Plugin) handleInvalidate(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var deleted int
+	var err error
+	switch {
+	case query.Get("table") != "":
+		deleted = p.InvalidateTable(r.Context(), query.Get("table"))
+	case query.Get("database") != "":
+		deleted, err = p.InvalidateDatabase(r.Context(), query.Get("database"))
+	case query.Get("fingerprint") != "":
+		deleted, err = p.InvalidateFingerprint(r.Context(), query.Get("fingerprint"))
+	case query.Get("tag") != "":
+		deleted, err = p.InvalidateTag(r.Context(), query.Get("tag"))
+	default:
+		p.writeJSON(w, http.StatusBadRequest, map[string]any{
+			"error": "one of table, database, fingerprint or tag is required",
+		})
+		return
+	}
+
+	if errors.Is(err, ErrInvalidFingerprint) || errors.Is(err, ErrInvalidTag) {
+		p.writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	} else if err != nil {
+		p.writeError(w, err)
+		return
+	}
+
+	p.Logger.Info("Invalidated cache via admin API", "query", query.Encode(), "deleted", deleted)
+	p.writeJSON(w, http.StatusOK, map[string]any{"deleted": deleted})
+}
+
+func (p *Plugin) handleFlush(w http.ResponseWriter, r *http.Request) {
+	deleted, err := p.Flush(r.Context())
+	if err != nil {
+		p.writeError(w, err)
+		return
+	}
+
+	p.Logger.Info("Flushed cache via admin API", "deleted", deleted)
+	p.writeJSON(w, http.StatusOK, map[string]any{"deleted": deleted})
+}
+
+func (p *Plugin) handleLookup(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	database := query.Get("database")
+	if database == "" {
+		database = p.DefaultDBName
+	}
+
+	if query.Get("server") == "" || database == "" || query.Get("sql") == "" {
+		p.writeJSON(w, http.StatusBadRequest, map[string]any{
+			"error": "server, database and sql are required",
+		})
+		return
+	}
+
+	result, err := p.Lookup(r.Context(), query.Get("server"), database, query.Get("sql"))
+	if err != nil {
+		p.writeError(w, err)
+		return
+	}
+
+	p.writeJSON(w, http.StatusOK, result)
+}
+
+func (p *Plugin) handleEntries(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultTopEntriesLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			p.writeJSON(w, http.StatusBadRequest, map[string]any{
+				"error": "limit must be a positive integer",
+			})
+			return
+		}
+		limit = parsed
+	}
+
+	entries, err := p.TopEntries(r.Context(), limit)
+	if err != nil {
+		p.writeError(w, err)
+		return
+	}
+
+	p.writeJSON(w, http.StatusOK, map[string]any{"entries": entries})
+}
+
+func (p *Plugin) handleExplain(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("server") == "" || query.Get("sql") == "" {
+		p.writeJSON(w, http.StatusBadRequest, map[string]any{
+			"error": "server and sql are required",
+		})
+		return
+	}
+
+	settings, err := ParseSettings(query["setting"])
+	if err != nil {
+		p.writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+
+	explanation, err := p.Explain(r.Context(), ExplainRequest{
+		Server:   query.Get("server"),
+		Database: query.Get("database"),
+		User:     query.Get("user"),
+		Session:  query.Get("session"),
+		Settings: settings,
+		SQL:      query.Get("sql"),
+	})
+	if err != nil {
+		p.writeError(w, err)
+		return
+	}
+
+	p.writeJSON(w, http.StatusOK, explanation)
+}
+
+func (p *Plugin) handleTopQueries(w http.ResponseWriter, r *http.Request) {
+	if p.TopQueries == nil {
+		p.writeJSON(w, http.StatusNotFound, map[string]any{
+			"error": "top queries are not tracked",
+		})
+		return
+	}
+
+	query := r.URL.Query()
+	limit := DefaultTopEntriesLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			p.writeJSON(w, http.StatusBadRequest, map[string]any{
+				"error": "limit must be a positive integer",
+			})
+			return
+		}
+		limit = parsed
+	}
+
+	sortBy := query.Get("sort")
+	switch sortBy {
+	case "":
+		sortBy = SortByLookups
+	case SortByLookups, SortByHits, SortByMisses, SortByBytes:
+	default:
+		p.writeJSON(w, http.StatusBadRequest, map[string]any{
+			"error": "sort must be one of lookups, hits, misses or bytes",
+		})
+		return
+	}
+
+	p.writeJSON(w, http.StatusOK, map[string]any{
+		"queries": p.TopQueries.Top(limit, sortBy),
+	})
+}
+
+func (p *Plugin) handleStaleResponses(w http.ResponseWriter, _ *http.Request) {
+	if p.StaleResponses == nil {
+		p.writeJSON(w, http.StatusNotFound, map[string]any{
+			"error": "stale responses are only recorded in shadow mode",
+		})
+		return
+	}
+
+	total, recent := p.StaleResponses.Recent()
+	p.writeJSON(w, http.StatusOK, map[string]any{"total": total, "responses": recent})
+}
+
+func (p *Plugin) writeError(w http.ResponseWriter, err error) {
+	p.Logger.Error("Failed to handle admin API request", "error", err)
+	p.writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+}
+
+func (p *Plugin) writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		p.Logger.Debug("Failed to write admin API response", "error", err)
+	}
+}
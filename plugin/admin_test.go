@@ -0,0 +1,180 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	pgproto3 "github.com/jackc/pgx/v5/pgproto3"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// populateCache stores a cached response and its table index keys.
+func populateCache(
+	t *testing.T, p *Plugin, redisClient *redis.Client,
+	server, database, query string, tables ...string,
+) string {
+	t.Helper()
+	ctx := context.Background()
+	request, err := (&pgproto3.Query{String: query}).Encode(nil)
+	assert.Nil(t, err)
+
+	cacheKey := p.getCacheKey(server, database, request)
+	redisClient.Set(ctx, p.responseKey(cacheKey), "response:"+query, time.Hour)
+	for _, table := range tables {
+		redisClient.Set(ctx, p.tableIndexKey(table, cacheKey), "", time.Hour)
+	}
+	return cacheKey
+}
+
+func TestInvalidateDatabase(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	ctx := context.Background()
+
+	users := populateCache(t, p, redisClient, "localhost:5432", "postgres", "SELECT * FROM users", "users")
+	other := populateCache(t, p, redisClient, "localhost:5432", "other", "SELECT * FROM users", "users")
+	noTables := populateCache(t, p, redisClient, "localhost:5432", "postgres", "SELECT 1")
+
+	deleted, err := p.InvalidateDatabase(ctx, "postgres")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, deleted)
+
+	assert.Equal(t, int64(0), redisClient.Exists(ctx, p.responseKey(users), p.responseKey(noTables)).Val())
+	assert.Equal(t, int64(0), redisClient.Exists(ctx, p.tableIndexKey("users", users)).Val())
+	assert.Equal(t, int64(2), redisClient.Exists(
+		ctx, p.responseKey(other), p.tableIndexKey("users", other)).Val())
+}
+
+func TestInvalidateFingerprint(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	ctx := context.Background()
+
+	users := populateCache(t, p, redisClient, "localhost:5432", "postgres", "SELECT * FROM users", "users")
+	posts := populateCache(t, p, redisClient, "localhost:5432", "postgres", "SELECT * FROM posts", "posts")
+
+	// Differently formatted queries share the same fingerprint.
+	fingerprint, err := fingerprintQuery("select *  from users")
+	assert.Nil(t, err)
+	deleted, err := p.InvalidateFingerprint(ctx, fingerprint)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, deleted)
+
+	assert.Equal(t, int64(0), redisClient.Exists(
+		ctx, p.responseKey(users), p.tableIndexKey("users", users)).Val())
+	assert.Equal(t, int64(2), redisClient.Exists(
+		ctx, p.responseKey(posts), p.tableIndexKey("posts", posts)).Val())
+
+	_, err = p.InvalidateFingerprint(ctx, "")
+	assert.ErrorIs(t, err, ErrInvalidFingerprint)
+}
+
+func TestFlushKeepsSessions(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	ctx := context.Background()
+
+	populateCache(t, p, redisClient, "localhost:5432", "postgres", "SELECT * FROM users", "users")
+	populateCache(t, p, redisClient, "localhost:5433", "other", "SELECT * FROM posts", "posts")
+	redisClient.Set(ctx, p.sessionKey("tcp:localhost:45320"), "postgres", 0)
+
+	deleted, err := p.Flush(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, deleted)
+
+	keys := redisClient.Keys(ctx, "*").Val()
+	assert.Equal(t, []string{p.sessionKey("tcp:localhost:45320")}, keys)
+}
+
+func TestLookupAndTopEntries(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	ctx := context.Background()
+
+	populateCache(t, p, redisClient, "localhost:5432", "postgres", "SELECT * FROM users", "users")
+	populateCache(t, p, redisClient, "localhost:5432", "postgres", "SELECT * FROM users WHERE id = 1", "users")
+
+	result, err := p.Lookup(ctx, "localhost:5432", "postgres", "SELECT * FROM users")
+	assert.Nil(t, err)
+	assert.True(t, result.Hit)
+	assert.Equal(t, int64(len("response:SELECT * FROM users")), result.Size)
+	assert.Equal(t, "1h0m0s", result.TTL)
+
+	result, err = p.Lookup(ctx, "localhost:5432", "postgres", "SELECT * FROM posts")
+	assert.Nil(t, err)
+	assert.False(t, result.Hit)
+
+	entries, err := p.TopEntries(ctx, 1)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "localhost:5432", entries[0].Cluster)
+	assert.Equal(t, "postgres", entries[0].Database)
+	assert.Equal(t, "SELECT * FROM users WHERE id = 1", entries[0].Query)
+	assert.Len(t, entries[0].Fingerprint, FingerprintLength)
+
+	entries, err = p.TopEntries(ctx, 10)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+	assert.GreaterOrEqual(t, entries[0].Size, entries[1].Size)
+}
+
+func TestAdminHandler(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	ctx := context.Background()
+	handler := p.AdminHandler()
+
+	cacheKey := populateCache(
+		t, p, redisClient, "localhost:5432", "postgres", "SELECT * FROM users", "users")
+
+	serve := func(method, target string) (int, map[string]any) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+		var body map[string]any
+		assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+		return recorder.Code, body
+	}
+
+	status, body := serve(http.MethodGet, "/lookup?"+url.Values{
+		"server":   {"localhost:5432"},
+		"database": {"postgres"},
+		"sql":      {"SELECT * FROM users"},
+	}.Encode())
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, true, body["hit"])
+
+	status, body = serve(http.MethodGet, "/entries?limit=5")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, body["entries"], 1)
+
+	status, _ = serve(http.MethodGet, "/entries?limit=zero")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = serve(http.MethodPost, "/invalidate")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = serve(http.MethodPost, "/invalidate?fingerprint=invalid")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = serve(http.MethodPost, "/invalidate?tag=tenant:*")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, body = serve(http.MethodPost, "/invalidate?tag=tenant:42")
+	assert.Equal(t, http.StatusOK, status)
+	assert.InDelta(t, 0, body["deleted"], 0)
+
+	status, body = serve(http.MethodPost, "/invalidate?table=users")
+	assert.Equal(t, http.StatusOK, status)
+	assert.InDelta(t, 2, body["deleted"], 0)
+	assert.Equal(t, int64(0), redisClient.Exists(ctx, p.responseKey(cacheKey)).Val())
+
+	status, body = serve(http.MethodPost, "/flush")
+	assert.Equal(t, http.StatusOK, status)
+	assert.InDelta(t, 0, body["deleted"], 0)
+}
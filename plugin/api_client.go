@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 
+	apiV1 "github.com/gatewayd-io/gatewayd/api/v1"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -43,3 +44,50 @@ func (p *Plugin) getProxies() map[string]map[string]Proxy {
 
 	return pxy
 }
+
+type serverConfig struct {
+	Clients map[string]map[string]struct {
+		Address string `json:"address"`
+	} `json:"clients"`
+}
+
+// getServerAddresses returns the addresses of the database servers GatewayD
+// connects to, as configured for the clients of its proxies.
+func (p *Plugin) getServerAddresses() map[string]struct{} {
+	if p.APIClient == nil {
+		p.Logger.Error(
+			"Failed to get the global config from GatewayD",
+			"error", "API client is not initialized",
+		)
+		return nil
+	}
+
+	globalConfig, err := p.APIClient.GetGlobalConfig(context.Background(), &apiV1.Group{})
+	if err != nil {
+		p.Logger.Error("Failed to get the global config from GatewayD", "error", err)
+		return nil
+	}
+
+	data, err := globalConfig.MarshalJSON()
+	if err != nil {
+		p.Logger.Error("Failed to marshal response from GatewayD", "error", err)
+		return nil
+	}
+
+	var config serverConfig
+	if err = json.Unmarshal(data, &config); err != nil {
+		p.Logger.Error("Failed to unmarshal response from GatewayD", "error", err)
+		return nil
+	}
+
+	addresses := map[string]struct{}{}
+	for _, group := range config.Clients {
+		for _, client := range group {
+			if client.Address != "" {
+				addresses[client.Address] = struct{}{}
+			}
+		}
+	}
+
+	return addresses
+}
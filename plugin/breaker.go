@@ -0,0 +1,408 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd-plugin-sdk/databases/postgres"
+	"github.com/hashicorp/go-hclog"
+	goRedis "github.com/redis/go-redis/v9"
+)
+
+// MaxMissedInvalidations is the number of tables and tags whose invalidation is
+// remembered while the breaker is open. Beyond that, the cache is flushed once
+// the breaker closes.
+const MaxMissedInvalidations = 1000
+
+// BreakerState is the state of the circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets all the Redis commands through.
+	BreakerClosed BreakerState = iota
+	// BreakerHalfOpen lets a limited number of probe commands through.
+	BreakerHalfOpen
+	// BreakerOpen rejects all the Redis commands and the hooks bypass the cache.
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker stops sending commands to Redis after consecutive failures, so
+// that a slow or unavailable Redis server doesn't add latency to the traffic.
+// After OpenDuration, up to HalfOpenProbes commands are let through, and the
+// breaker closes if all of them succeed. It is installed as a Redis client hook.
+type CircuitBreaker struct {
+	FailureThreshold int
+	OpenDuration     time.Duration
+	HalfOpenProbes   int
+
+	logger hclog.Logger
+	now    func() time.Time
+
+	mutex     sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+
+	// missed are the invalidations of the writes sent while the cache was
+	// bypassed, which are applied before the cache is used again.
+	missed     missedInvalidations
+	recovering bool
+}
+
+// missedInvalidations are the tables and tags whose cached responses must be
+// invalidated, or all the cached responses if there were too many or the tables
+// of a write were unknown.
+type missedInvalidations struct {
+	tables   map[string]struct{}
+	tags     map[string]struct{}
+	overflow bool
+}
+
+func (m *missedInvalidations) empty() bool {
+	return len(m.tables) == 0 && len(m.tags) == 0 && !m.overflow
+}
+
+func (m *missedInvalidations) add(tables, tags []string, unknown bool) {
+	if m.tables == nil {
+		m.tables = map[string]struct{}{}
+		m.tags = map[string]struct{}{}
+	}
+	for _, table := range tables {
+		m.tables[table] = struct{}{}
+	}
+	for _, tag := range tags {
+		m.tags[tag] = struct{}{}
+	}
+
+	if unknown || len(m.tables)+len(m.tags) > MaxMissedInvalidations {
+		m.tables = map[string]struct{}{}
+		m.tags = map[string]struct{}{}
+		m.overflow = true
+	}
+}
+
+var _ goRedis.Hook = (*CircuitBreaker)(nil)
+
+// NewCircuitBreaker returns a closed circuit breaker.
+func NewCircuitBreaker(
+	failureThreshold int, openDuration time.Duration, halfOpenProbes int, logger hclog.Logger,
+) *CircuitBreaker {
+	CircuitBreakerStateGauge.Set(float64(BreakerClosed))
+	return &CircuitBreaker{
+		FailureThreshold: max(failureThreshold, 1),
+		OpenDuration:     openDuration,
+		HalfOpenProbes:   max(halfOpenProbes, 1),
+		logger:           logger,
+		now:              time.Now,
+	}
+}
+
+// State returns the state of the circuit breaker. An open breaker becomes
+// half-open once OpenDuration has passed.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.OpenDuration {
+		b.transition(BreakerHalfOpen)
+	}
+	return b.state
+}
+
+// Allow checks if a command can be sent to Redis. Every allowed command
+// must be followed by a call to Record.
+func (b *CircuitBreaker) Allow() bool {
+	switch b.State() {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if b.state == BreakerClosed {
+			return true
+		}
+		if b.state == BreakerHalfOpen && b.probes < b.HalfOpenProbes {
+			b.probes++
+			return true
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// Record records the result of a command sent to Redis.
+func (b *CircuitBreaker) Record(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !isRedisFailure(err) {
+		switch b.state {
+		case BreakerClosed:
+			b.failures = 0
+		case BreakerHalfOpen:
+			b.successes++
+			if b.successes >= b.HalfOpenProbes {
+				b.transition(BreakerClosed)
+			}
+		case BreakerOpen:
+		}
+		return
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		b.failures++
+		if b.failures >= b.FailureThreshold {
+			b.logger.Warn("Too many Redis failures, bypassing the cache",
+				"failures", b.failures, "openDuration", b.OpenDuration.String(), "error", err)
+			b.transition(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		b.logger.Warn("Redis probe failed, bypassing the cache",
+			"openDuration", b.OpenDuration.String(), "error", err)
+		b.transition(BreakerOpen)
+	case BreakerOpen:
+	}
+}
+
+// transition changes the state of the breaker. The mutex must be held.
+func (b *CircuitBreaker) transition(state BreakerState) {
+	b.logger.Info("Redis circuit breaker changed state",
+		"from", b.state.String(), "to", state.String())
+
+	b.state = state
+	b.failures = 0
+	b.probes = 0
+	b.successes = 0
+	if state == BreakerOpen {
+		b.openedAt = b.now()
+	}
+	CircuitBreakerStateGauge.Set(float64(state))
+}
+
+func (b *CircuitBreaker) DialHook(next goRedis.DialHook) goRedis.DialHook {
+	return next
+}
+
+func (b *CircuitBreaker) ProcessHook(next goRedis.ProcessHook) goRedis.ProcessHook {
+	return func(ctx context.Context, cmd goRedis.Cmder) error {
+		if !b.Allow() {
+			cmd.SetErr(ErrCircuitOpen)
+			return ErrCircuitOpen
+		}
+
+		err := next(ctx, cmd)
+		b.Record(err)
+		return err
+	}
+}
+
+func (b *CircuitBreaker) ProcessPipelineHook(next goRedis.ProcessPipelineHook) goRedis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []goRedis.Cmder) error {
+		if !b.Allow() {
+			for _, cmd := range cmds {
+				cmd.SetErr(ErrCircuitOpen)
+			}
+			return ErrCircuitOpen
+		}
+
+		err := next(ctx, cmds)
+		b.Record(err)
+		return err
+	}
+}
+
+// RecordMissedInvalidation records the tables and the tags of a write sent while
+// the cache is bypassed, or that its tables are unknown.
+func (b *CircuitBreaker) RecordMissedInvalidation(tables, tags []string, unknown bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.missed.add(tables, tags, unknown)
+	CacheMissedInvalidationsCounter.Inc()
+}
+
+// pendingInvalidations checks if invalidations missed while the cache was
+// bypassed are not applied yet.
+func (b *CircuitBreaker) pendingInvalidations() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.recovering || !b.missed.empty()
+}
+
+// beginRecovery takes the missed invalidations to apply them, if the breaker is
+// closed and they are not being applied already.
+func (b *CircuitBreaker) beginRecovery() (missedInvalidations, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state != BreakerClosed || b.recovering || b.missed.empty() {
+		return missedInvalidations{}, false
+	}
+
+	missed := b.missed
+	b.missed = missedInvalidations{}
+	b.recovering = true
+	return missed, true
+}
+
+// endRecovery ends the recovery. The missed invalidations are kept to be applied
+// again if they failed.
+func (b *CircuitBreaker) endRecovery(missed missedInvalidations, applied bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.recovering = false
+	if !applied {
+		b.missed.add(slices.Collect(maps.Keys(missed.tables)), slices.Collect(maps.Keys(missed.tags)),
+			missed.overflow)
+	}
+}
+
+// isRedisFailure checks if an error means that Redis is unavailable. Errors
+// returned by Redis itself, including missing keys, mean that it is available.
+func isRedisFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var redisError goRedis.Error
+	return !errors.As(err, &redisError)
+}
+
+// cacheBypassed checks if the hooks should bypass the cache, because the
+// circuit breaker is open. Writes sent while the cache is bypassed are recorded
+// by the hooks, as are the invalidations that failed, and the cache keeps being
+// bypassed until RecoverMissedInvalidations applies them after the breaker
+// closes, so that no stale response is served.
+func (p *Plugin) cacheBypassed() bool {
+	if p.CircuitBreaker == nil {
+		return false
+	}
+
+	state := p.CircuitBreaker.State()
+	if state != BreakerOpen && !p.CircuitBreaker.pendingInvalidations() {
+		return false
+	}
+
+	if state == BreakerClosed {
+		select {
+		case p.recoveryRequests <- struct{}{}:
+		default:
+			// The recovery is already requested.
+		}
+	}
+
+	CacheBypassedCounter.Inc()
+	return true
+}
+
+// RecoverMissedInvalidations applies the invalidations missed while the cache
+// was bypassed once the circuit breaker is closed, until Shutdown is called or
+// the context is cancelled. It must be running if CircuitBreaker is set, and be
+// added to the WaitGroup, like UpdateCache.
+func (p *Plugin) RecoverMissedInvalidations(ctx context.Context) {
+	defer p.WaitGroup.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stopping:
+			return
+		case <-p.recoveryRequests:
+		}
+
+		if missed, ok := p.CircuitBreaker.beginRecovery(); ok {
+			p.applyMissedInvalidations(ctx, missed)
+		}
+	}
+}
+
+// recordFailedInvalidation records the tables and the tags whose invalidation
+// failed, e.g. because the breaker rejected the commands while half-open, so that
+// they are invalidated again before the cache is used.
+func (p *Plugin) recordFailedInvalidation(tables, tags []string) {
+	if p.CircuitBreaker == nil {
+		return
+	}
+
+	p.Logger.Warn("Failed to invalidate cached responses, bypassing the cache until they are",
+		"tables", tables, "tags", tags)
+	p.CircuitBreaker.RecordMissedInvalidation(tables, tags, false)
+}
+
+// recordMissedInvalidation records the tables or tags the query would have
+// invalidated if the cache was not bypassed.
+func (p *Plugin) recordMissedInvalidation(query string) {
+	querySQL, err := p.decodeQuery(query)
+	if err != nil {
+		return
+	}
+
+	tags := parseHints(querySQL).invalidateTags
+	var tables []string
+	if invalidatesTables(strings.ToUpper(querySQL)) {
+		if tables, err = postgres.GetTablesFromQuery(querySQL); err != nil {
+			// The tables of the write are unknown, so all the cached responses
+			// might be stale.
+			p.CircuitBreaker.RecordMissedInvalidation(nil, nil, true)
+			return
+		}
+	}
+
+	if len(tables) > 0 || len(tags) > 0 {
+		p.CircuitBreaker.RecordMissedInvalidation(tables, tags, false)
+	}
+}
+
+// applyMissedInvalidations invalidates the cached responses of the tables and
+// the tags written while the cache was bypassed, or flushes the cache if they
+// are unknown.
+func (p *Plugin) applyMissedInvalidations(ctx context.Context, missed missedInvalidations) {
+	var deleted int
+	var err error
+	if missed.overflow {
+		deleted, err = p.Flush(ctx)
+	} else {
+		deleted = p.invalidateTables(ctx, slices.Sorted(maps.Keys(missed.tables)))
+		if len(missed.tags) > 0 {
+			var tagDeleted int
+			tagDeleted, err = p.invalidateTags(ctx, slices.Sorted(maps.Keys(missed.tags)))
+			deleted += tagDeleted
+		}
+	}
+
+	// Table invalidations don't report errors, but fail if the breaker opens again.
+	applied := err == nil && p.CircuitBreaker.State() == BreakerClosed
+	p.CircuitBreaker.endRecovery(missed, applied)
+	if !applied {
+		p.Logger.Warn("Failed to apply the invalidations missed while the cache was bypassed", "error", err)
+		return
+	}
+
+	p.Logger.Info("Applied the invalidations missed while the cache was bypassed",
+		"tables", len(missed.tables), "tags", len(missed.tags), "flushed", missed.overflow, "deleted", deleted)
+}
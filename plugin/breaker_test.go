@@ -0,0 +1,255 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	sdkAct "github.com/gatewayd-io/gatewayd-plugin-sdk/act"
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+var errConnectionRefused = errors.New("dial tcp 127.0.0.1:6379: connect: connection refused")
+
+func newTestCircuitBreaker(now *time.Time) *CircuitBreaker {
+	breaker := NewCircuitBreaker(2, time.Second, 2, hclog.NewNullLogger())
+	breaker.now = func() time.Time { return *now }
+	return breaker
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := newTestCircuitBreaker(&now)
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+	// Missing keys and errors returned by Redis don't count as failures.
+	breaker.Record(redis.Nil)
+	breaker.Record(errConnectionRefused)
+	breaker.Record(nil)
+	breaker.Record(errConnectionRefused)
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+	// Consecutive failures open the breaker.
+	breaker.Record(errConnectionRefused)
+	assert.Equal(t, BreakerOpen, breaker.State())
+	assert.Equal(t, float64(BreakerOpen), testutil.ToFloat64(CircuitBreakerStateGauge))
+	assert.False(t, breaker.Allow())
+
+	// After the open duration, a limited number of probes are let through.
+	now = now.Add(time.Second)
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+	assert.True(t, breaker.Allow())
+	assert.True(t, breaker.Allow())
+	assert.False(t, breaker.Allow())
+
+	// A failing probe opens the breaker again.
+	breaker.Record(errConnectionRefused)
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	// Successful probes close the breaker.
+	now = now.Add(time.Second)
+	assert.True(t, breaker.Allow())
+	assert.True(t, breaker.Allow())
+	breaker.Record(nil)
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+	breaker.Record(nil)
+	assert.Equal(t, BreakerClosed, breaker.State())
+	assert.Equal(t, float64(BreakerClosed), testutil.ToFloat64(CircuitBreakerStateGauge))
+}
+
+func TestCircuitBreakerBypassesCache(t *testing.T) {
+	p, _ := newTestPlugin(t)
+	breaker := NewCircuitBreaker(1, time.Minute, 1, hclog.NewNullLogger())
+	p.Impl.CircuitBreaker = breaker
+
+	// A Redis server that is not reachable.
+	p.Impl.RedisClient = redis.NewClient(&redis.Options{
+		Addr:       "127.0.0.1:1",
+		MaxRetries: -1,
+	})
+	p.Impl.RedisClient.AddHook(breaker)
+	ctx := context.Background()
+
+	_, request := testQueryRequest()
+	args := map[string]interface{}{
+		"request": request,
+		"client": map[string]interface{}{
+			"remote": "localhost:45320",
+		},
+		"server": map[string]interface{}{
+			"remote": "localhost:5432",
+		},
+	}
+	req, _ := v1.NewStruct(args)
+
+	// The failing session lookup opens the breaker.
+	_, err := p.Impl.OnTrafficFromClient(ctx, req)
+	assert.Nil(t, err)
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	// While the breaker is open, the hooks return immediately.
+	bypassed := testutil.ToFloat64(CacheBypassedCounter)
+	result, err := p.Impl.OnTrafficFromClient(ctx, req)
+	assert.Nil(t, err)
+	assert.Equal(t, req, result)
+	_, err = p.Impl.OnTrafficFromServer(ctx, req)
+	assert.Nil(t, err)
+	assert.Empty(t, p.Impl.UpdateCacheChannel)
+	assert.Equal(t, bypassed+2, testutil.ToFloat64(CacheBypassedCounter))
+
+	// Commands sent outside the hooks are rejected without reaching Redis.
+	assert.ErrorIs(t, p.Impl.RedisClient.Get(ctx, "key").Err(), ErrCircuitOpen)
+}
+
+// newBreakerTestPlugin returns a plugin whose Redis client goes through the
+// breaker, with the missed invalidations recovered in the background, along with
+// a client without breaker, and the key of a cached response of the users table.
+func newBreakerTestPlugin(t *testing.T, breaker *CircuitBreaker) (*Plugin, *redis.Client, string) {
+	t.Helper()
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	p.DefaultDBName = "postgres"
+	p.CircuitBreaker = breaker
+	p.RedisClient = redis.NewClient(redisClient.Options())
+	p.RedisClient.AddHook(breaker)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	// The connection is set up before the breaker opens.
+	assert.Nil(t, p.RedisClient.Ping(ctx).Err())
+
+	p.WaitGroup.Add(1)
+	go p.RecoverMissedInvalidations(ctx)
+
+	cacheKey := testCacheKey(t, p, "SELECT * FROM users")
+	redisClient.Set(ctx, p.responseKey(cacheKey), "response", time.Hour)
+	redisClient.Set(ctx, p.tableIndexKey("users", cacheKey), "", time.Hour)
+	return p, redisClient, cacheKey
+}
+
+// breakerTestRequest returns the request of a simple query sent via GatewayD.
+func breakerTestRequest(t *testing.T, query string) *v1.Struct {
+	t.Helper()
+	request, err := (&pgproto3.Query{String: query}).Encode(nil)
+	assert.Nil(t, err)
+	req, err := v1.NewStruct(map[string]interface{}{
+		"request": request,
+		"client": map[string]interface{}{
+			"remote": "localhost:45320",
+		},
+		"server": map[string]interface{}{
+			"remote": "localhost:5432",
+		},
+	})
+	assert.Nil(t, err)
+	return req
+}
+
+// assertMissedInvalidationsApplied checks that the cache is bypassed until the
+// stale response of the users table is deleted, and that it is not served after.
+func assertMissedInvalidationsApplied(t *testing.T, p *Plugin, redisClient *redis.Client, cacheKey string) {
+	t.Helper()
+	ctx := context.Background()
+	assert.Equal(t, BreakerClosed, p.CircuitBreaker.State())
+
+	result, err := p.OnTrafficFromClient(ctx, breakerTestRequest(t, "SELECT * FROM users"))
+	assert.Nil(t, err)
+	assert.NotContains(t, result.AsMap(), "response")
+	assert.Eventually(t, func() bool {
+		return !p.CircuitBreaker.pendingInvalidations()
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(0), redisClient.Exists(ctx,
+		p.responseKey(cacheKey), p.tableIndexKey("users", cacheKey)).Val())
+
+	result, err = p.OnTrafficFromClient(ctx, breakerTestRequest(t, "SELECT * FROM users"))
+	assert.Nil(t, err)
+	assert.NotContains(t, result.AsMap(), "response")
+	assert.NotContains(t, result.AsMap(), sdkAct.Signals)
+}
+
+func TestCircuitBreakerInvalidatesMissedWrites(t *testing.T) {
+	now := time.Now()
+	breaker := newTestCircuitBreaker(&now)
+	p, redisClient, cacheKey := newBreakerTestPlugin(t, breaker)
+	ctx := context.Background()
+
+	// The insert is sent while the breaker is open, so it can't invalidate the
+	// cached response.
+	breaker.Record(errConnectionRefused)
+	breaker.Record(errConnectionRefused)
+	assert.Equal(t, BreakerOpen, breaker.State())
+	_, err := p.OnTrafficFromClient(ctx, breakerTestRequest(t, "INSERT INTO users VALUES (1)"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), redisClient.Exists(ctx, p.responseKey(cacheKey)).Val())
+
+	// Successful probes close the breaker.
+	now = now.Add(time.Second)
+	assert.Nil(t, p.RedisClient.Ping(ctx).Err())
+	assert.Nil(t, p.RedisClient.Ping(ctx).Err())
+
+	assertMissedInvalidationsApplied(t, p, redisClient, cacheKey)
+}
+
+func TestCircuitBreakerInvalidatesWritesRejectedWhileHalfOpen(t *testing.T) {
+	now := time.Now()
+	breaker := newTestCircuitBreaker(&now)
+	p, redisClient, cacheKey := newBreakerTestPlugin(t, breaker)
+	ctx := context.Background()
+
+	// All the probes of the half-open breaker are in flight, so the commands
+	// invalidating the table are rejected.
+	breaker.Record(errConnectionRefused)
+	breaker.Record(errConnectionRefused)
+	now = now.Add(time.Second)
+	assert.True(t, breaker.Allow())
+	assert.True(t, breaker.Allow())
+	_, err := p.OnTrafficFromClient(ctx, breakerTestRequest(t, "INSERT INTO users VALUES (1)"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), redisClient.Exists(ctx, p.responseKey(cacheKey)).Val())
+	assert.True(t, breaker.pendingInvalidations())
+
+	// The probes succeed and close the breaker.
+	breaker.Record(nil)
+	breaker.Record(nil)
+
+	assertMissedInvalidationsApplied(t, p, redisClient, cacheKey)
+}
+
+func TestRecoverMissedInvalidationsStopsOnShutdown(t *testing.T) {
+	now := time.Now()
+	p, _, _ := newBreakerTestPlugin(t, newTestCircuitBreaker(&now))
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	p.UpdateCacheChannel = make(chan *v1.Struct)
+	assert.Nil(t, p.Shutdown(drainCtx))
+}
+
+func TestMissedInvalidationsOverflow(t *testing.T) {
+	var missed missedInvalidations
+	assert.True(t, missed.empty())
+
+	missed.add([]string{"users"}, []string{"tenant:42"}, false)
+	assert.False(t, missed.empty())
+	assert.False(t, missed.overflow)
+
+	// Too many tables flush the cache instead.
+	tables := make([]string, MaxMissedInvalidations)
+	for i := range tables {
+		tables[i] = fmt.Sprintf("table_%d", i)
+	}
+	missed.add(tables, nil, false)
+	assert.True(t, missed.overflow)
+	assert.Empty(t, missed.tables)
+
+	// So do writes whose tables are unknown.
+	missed = missedInvalidations{}
+	missed.add(nil, nil, true)
+	assert.True(t, missed.overflow)
+}
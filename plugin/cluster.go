@@ -0,0 +1,107 @@
+package plugin
+
+import (
+	"net"
+	"strings"
+)
+
+// ParseServerGroups parses a comma-separated list of address=cluster pairs
+// into a map of normalized backend addresses to logical cluster names.
+// For example: "10.0.0.1:5432=main,10.0.0.2:5432=main,10.0.1.1:5432=analytics".
+func ParseServerGroups(groups string) (map[string]string, error) {
+	serverGroups := map[string]string{}
+	for _, pair := range strings.Split(groups, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		// Split on the last "=", so that the address part is kept intact.
+		separator := strings.LastIndex(pair, "=")
+		if separator <= 0 || separator == len(pair)-1 {
+			return nil, ErrInvalidServerGroup
+		}
+
+		address := strings.TrimSpace(pair[:separator])
+		cluster := strings.TrimSpace(pair[separator+1:])
+		// Braces delimit the cluster name in cache keys.
+		if address == "" || cluster == "" || strings.ContainsAny(cluster, "{}") {
+			return nil, ErrInvalidServerGroup
+		}
+
+		serverGroups[normalizeServerAddress(address)] = cluster
+	}
+
+	return serverGroups, nil
+}
+
+// lookupHost resolves the host names of servers. It is replaced in tests.
+var lookupHost = net.LookupHost
+
+// resolveServerAddress returns the addresses a server may have in cache keys.
+// GatewayD reports the remote address of its connections to the server, i.e. an
+// IP and a port, so a host name is resolved to an address per IP, along with the
+// address itself, which may be mapped to a cluster by the server groups.
+func resolveServerAddress(address string) []string {
+	addresses := []string{normalizeServerAddress(address)}
+	host, port, err := net.SplitHostPort(strings.TrimSpace(address))
+	if err != nil || net.ParseIP(host) != nil {
+		return addresses
+	}
+
+	ips, err := lookupHost(strings.ToLower(host))
+	if err != nil {
+		return addresses
+	}
+	for _, ip := range ips {
+		addresses = append(addresses, normalizeServerAddress(net.JoinHostPort(ip, port)))
+	}
+
+	return addresses
+}
+
+// serverAddresses returns an address per cluster a server has in cache keys,
+// i.e. per IP it resolves to, or per cluster it is mapped to by the server groups.
+// A host name that is neither resolved nor mapped never matches the addresses
+// reported by GatewayD, so it has none.
+func (p *Plugin) serverAddresses(address string) []string {
+	var addresses []string
+	clusters := map[string]struct{}{}
+	for _, resolved := range resolveServerAddress(address) {
+		host, _, err := net.SplitHostPort(resolved)
+		if _, mapped := p.ServerGroups[resolved]; !mapped && (err != nil || net.ParseIP(host) == nil) {
+			continue
+		}
+
+		cluster := p.getClusterName(resolved)
+		if _, ok := clusters[cluster]; !ok {
+			clusters[cluster] = struct{}{}
+			addresses = append(addresses, resolved)
+		}
+	}
+
+	return addresses
+}
+
+// normalizeServerAddress returns the canonical host:port form of a backend address,
+// so that the same server is always represented by the same string.
+func normalizeServerAddress(address string) string {
+	address = strings.TrimSpace(address)
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return strings.ToLower(address)
+	}
+
+	return net.JoinHostPort(strings.ToLower(host), port)
+}
+
+// getClusterName returns the logical cluster name of a backend address.
+// Addresses that are not mapped to a cluster are their own cluster.
+func (p *Plugin) getClusterName(address string) string {
+	normalized := normalizeServerAddress(address)
+	if cluster, ok := p.ServerGroups[normalized]; ok {
+		return cluster
+	}
+
+	return normalized
+}
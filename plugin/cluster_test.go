@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseServerGroups(t *testing.T) {
+	groups, err := ParseServerGroups(
+		"10.0.0.1:5432=main, 10.0.0.2:5432=main,[::1]:5432=local,DB.example.com:5432=analytics")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"10.0.0.1:5432":       "main",
+		"10.0.0.2:5432":       "main",
+		"[::1]:5432":          "local",
+		"db.example.com:5432": "analytics",
+	}, groups)
+}
+
+func Test_ParseServerGroups_Empty(t *testing.T) {
+	groups, err := ParseServerGroups("")
+	assert.Nil(t, err)
+	assert.Empty(t, groups)
+}
+
+func Test_ParseServerGroups_Fails(t *testing.T) {
+	for _, groups := range []string{"10.0.0.1:5432", "=main", "10.0.0.1:5432=", "10.0.0.1:5432= ", "10.0.0.1:5432={main}"} {
+		_, err := ParseServerGroups(groups)
+		assert.ErrorIs(t, err, ErrInvalidServerGroup, groups)
+	}
+}
+
+func Test_getClusterName(t *testing.T) {
+	p := Plugin{
+		ServerGroups: map[string]string{
+			"10.0.0.1:5432": "main",
+			"10.0.0.2:5432": "main",
+		},
+	}
+	assert.Equal(t, "main", p.getClusterName("10.0.0.1:5432"))
+	assert.Equal(t, "main", p.getClusterName(" 10.0.0.2:5432 "))
+	assert.Equal(t, "10.0.0.3:5432", p.getClusterName("10.0.0.3:5432"))
+	assert.Equal(t, "localhost:5432", p.getClusterName("LOCALHOST:5432"))
+	assert.Equal(t, "[::1]:5432", p.getClusterName("[::1]:5432"))
+}
+
+func Test_resolveServerAddress(t *testing.T) {
+	stubLookupHost(t, map[string][]string{"db.internal": {"127.0.0.1", "::1"}})
+
+	assert.Equal(t, []string{"db.internal:5432", "127.0.0.1:5432", "[::1]:5432"},
+		resolveServerAddress("DB.internal:5432"))
+	assert.Equal(t, []string{"10.0.0.1:5432"}, resolveServerAddress("10.0.0.1:5432"))
+	assert.Equal(t, []string{"unknown:5432"}, resolveServerAddress("unknown:5432"))
+}
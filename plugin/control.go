@@ -0,0 +1,196 @@
+package plugin
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+
+	sdkAct "github.com/gatewayd-io/gatewayd-plugin-sdk/act"
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// Control statements are queries that are answered by the plugin instead of
+// the server, so that applications can direct the cache over their normal
+// connection, e.g.
+//
+//	SELECT gatewayd_cache_invalidate('users', 'posts');
+//	SELECT gatewayd_cache_invalidate_tag('tenant:42');
+//
+// They return the number of deleted keys. Only simple queries are intercepted.
+const (
+	// InvalidateFunction invalidates the cached responses of the tables.
+	InvalidateFunction = "gatewayd_cache_invalidate"
+	// InvalidateTagFunction invalidates the cached responses of the tags.
+	InvalidateTagFunction = "gatewayd_cache_invalidate_tag"
+
+	// OIDInt8 is the type of the column of the control statements' response.
+	OIDInt8 = 20
+
+	// SQLSTATE codes of the errors of control statements.
+	invalidParameterValue = "22023"
+	internalError         = "XX000"
+
+	// Results of control statements.
+	ControlStatementSucceeded = "succeeded"
+	ControlStatementFailed    = "failed"
+)
+
+var (
+	controlStatementPattern = regexp.MustCompile(
+		`(?i)^SELECT\s+(gatewayd_cache_invalidate(?:_tag)?)\s*\(\s*` +
+			`('(?:[^']|'')*'(?:\s*,\s*'(?:[^']|'')*')*)\s*\)\s*;?\s*$`)
+	stringLiteralPattern = regexp.MustCompile(`'((?:[^']|'')*)'`)
+)
+
+// controlStatement is a control statement parsed from a query.
+type controlStatement struct {
+	function  string
+	arguments []string
+}
+
+// parseControlStatement returns the control statement of the query, or nil if
+// the query is not one. Leading comments are ignored.
+func parseControlStatement(sql string) *controlStatement {
+	if !strings.Contains(strings.ToLower(sql), InvalidateFunction) {
+		return nil
+	}
+
+	match := controlStatementPattern.FindStringSubmatch(trimLeadingComments(sql))
+	if match == nil {
+		return nil
+	}
+
+	statement := &controlStatement{function: strings.ToLower(match[1])}
+	for _, literal := range stringLiteralPattern.FindAllStringSubmatch(match[2], -1) {
+		statement.arguments = append(statement.arguments, strings.ReplaceAll(literal[1], "''", "'"))
+	}
+	return statement
+}
+
+// isValidTable checks whether the table can be used in the pattern of the keys
+// of its table index.
+func isValidTable(table string) bool {
+	return table != "" && !strings.ContainsAny(table, `*?[]\`)
+}
+
+// runControlStatement runs the invalidation of the control statement and returns
+// the number of deleted keys, or the error to send to the client.
+func (p *Plugin) runControlStatement(
+	ctx context.Context, statement *controlStatement,
+) (int, *pgproto3.ErrorResponse) {
+	if p.cacheBypassed() {
+		return 0, &pgproto3.ErrorResponse{
+			Severity: "ERROR", Code: internalError, Message: ErrCircuitOpen.Error(),
+		}
+	}
+
+	switch statement.function {
+	case InvalidateTagFunction:
+		for _, tag := range statement.arguments {
+			if !isValidTag(tag) {
+				return 0, &pgproto3.ErrorResponse{
+					Severity: "ERROR", Code: invalidParameterValue,
+					Message: ErrInvalidTag.Error() + ": " + strconv.Quote(tag),
+				}
+			}
+		}
+
+		deleted, err := p.invalidateTags(ctx, statement.arguments)
+		if err != nil {
+			return deleted, &pgproto3.ErrorResponse{
+				Severity: "ERROR", Code: internalError, Message: err.Error(),
+			}
+		}
+		return deleted, nil
+	default:
+		for _, table := range statement.arguments {
+			if !isValidTable(table) {
+				return 0, &pgproto3.ErrorResponse{
+					Severity: "ERROR", Code: invalidParameterValue,
+					Message: ErrInvalidTable.Error() + ": " + strconv.Quote(table),
+				}
+			}
+		}
+
+		return p.invalidateTables(ctx, statement.arguments), nil
+	}
+}
+
+// answerControlStatement runs the control statement of the query, if it is one,
+// and sets the response of the plugin on the request, along with the Terminate
+// signal, so that the query never reaches the server. It returns whether the
+// query was a control statement.
+func (p *Plugin) answerControlStatement(
+	ctx context.Context, req *v1.Struct, query string, client map[string]string,
+) bool {
+	querySQL, err := p.decodeQuery(query)
+	if err != nil {
+		return false
+	}
+
+	statement := parseControlStatement(querySQL)
+	if statement == nil {
+		return false
+	}
+
+	ctx, span := p.startSpan(ctx, "cache.control")
+	defer span.End()
+
+	deleted, errorResponse := p.runControlStatement(ctx, statement)
+
+	// The transaction status of the session is kept as is.
+	status := byte('I')
+	if sessionID, err := clientSessionID(client); err == nil && p.Sessions != nil {
+		if session, ok := p.Sessions.Get(sessionID); ok && session.TransactionStatus != 0 {
+			status = session.TransactionStatus
+		}
+	}
+
+	var messages []pgproto3.BackendMessage
+	if errorResponse != nil {
+		ControlStatementsCounter.WithLabelValues(statement.function, ControlStatementFailed).Inc()
+		p.Logger.Warn("Failed to run control statement",
+			"function", statement.function, "arguments", statement.arguments, "error", errorResponse.Message)
+		messages = append(messages, errorResponse)
+	} else {
+		ControlStatementsCounter.WithLabelValues(statement.function, ControlStatementSucceeded).Inc()
+		p.Logger.Info("Ran control statement",
+			"function", statement.function, "arguments", statement.arguments, "deleted", deleted)
+		messages = append(messages,
+			&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{
+				Name: []byte(statement.function), DataTypeOID: OIDInt8, DataTypeSize: 8, TypeModifier: -1,
+			}}},
+			&pgproto3.DataRow{Values: [][]byte{[]byte(strconv.Itoa(deleted))}},
+			&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")},
+		)
+	}
+	messages = append(messages, &pgproto3.ReadyForQuery{TxStatus: status})
+
+	var response []byte
+	for _, message := range messages {
+		if response, err = message.Encode(response); err != nil {
+			CacheErrorsCounter.Inc()
+			p.Logger.Error("Failed to encode the response of the control statement", "error", err)
+			return false
+		}
+	}
+
+	signals, err := v1.NewList([]any{
+		sdkAct.Terminate().ToMap(),
+		sdkAct.Log("debug", "Answered control statement", map[string]any{
+			"function": statement.function,
+			"plugin":   PluginID.GetName(),
+		}).ToMap(),
+	})
+	if err != nil {
+		CacheErrorsCounter.Inc()
+		p.Logger.Error("Failed to create signals", "error", err)
+		return false
+	}
+
+	req.Fields[sdkAct.Signals] = v1.NewListValue(signals)
+	req.Fields["response"] = v1.NewBytesValue(response)
+	return true
+}
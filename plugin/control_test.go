@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	sdkAct "github.com/gatewayd-io/gatewayd-plugin-sdk/act"
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseControlStatement(t *testing.T) {
+	statement := parseControlStatement("-- Orders were imported\nselect GATEWAYD_CACHE_INVALIDATE('users', 'o''rders');")
+	assert.Equal(t, &controlStatement{
+		function:  InvalidateFunction,
+		arguments: []string{"users", "o'rders"},
+	}, statement)
+
+	statement = parseControlStatement("SELECT gatewayd_cache_invalidate_tag( 'tenant:42' )")
+	assert.Equal(t, &controlStatement{
+		function:  InvalidateTagFunction,
+		arguments: []string{"tenant:42"},
+	}, statement)
+
+	assert.Nil(t, parseControlStatement("SELECT gatewayd_cache_invalidate()"))
+	assert.Nil(t, parseControlStatement("SELECT gatewayd_cache_invalidate(name) FROM tables"))
+	assert.Nil(t, parseControlStatement("SELECT gatewayd_cache_invalidate('users'), 1"))
+	assert.Nil(t, parseControlStatement("SELECT * FROM users"))
+}
+
+func TestControlStatement(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	p.DefaultDBName = "postgres"
+	ctx := context.Background()
+
+	users := populateCache(t, p, redisClient, "localhost:5432", "postgres", "SELECT * FROM users", "users")
+	posts := populateCache(t, p, redisClient, "localhost:5432", "postgres", "SELECT * FROM posts", "posts")
+	p.Sessions.Register("tcp:localhost:45320", Session{Database: "postgres", TransactionStatus: 'T'})
+
+	// The control statement is answered by the plugin with the messages of the response.
+	answer := func(sql string) []pgproto3.BackendMessage {
+		request, err := (&pgproto3.Query{String: sql}).Encode(nil)
+		assert.Nil(t, err)
+		req, err := v1.NewStruct(map[string]interface{}{
+			"request": request,
+			"client": map[string]interface{}{
+				"remote": "localhost:45320",
+			},
+			"server": map[string]interface{}{
+				"remote": "localhost:5432",
+			},
+		})
+		assert.Nil(t, err)
+		result, err := p.OnTrafficFromClient(ctx, req)
+		assert.Nil(t, err)
+		assert.Contains(t, result.GetFields(), sdkAct.Signals)
+
+		frontend := pgproto3.NewFrontend(bytes.NewReader(result.GetFields()["response"].GetBytesValue()), nil)
+		var messages []pgproto3.BackendMessage
+		for {
+			message, err := frontend.Receive()
+			if err != nil {
+				return messages
+			}
+			switch message := message.(type) {
+			case *pgproto3.RowDescription:
+				messages = append(messages, &pgproto3.RowDescription{Fields: message.Fields})
+			case *pgproto3.DataRow:
+				messages = append(messages, &pgproto3.DataRow{Values: message.Values})
+			case *pgproto3.CommandComplete:
+				messages = append(messages, &pgproto3.CommandComplete{CommandTag: message.CommandTag})
+			case *pgproto3.ErrorResponse:
+				messages = append(messages, &pgproto3.ErrorResponse{Code: message.Code})
+			case *pgproto3.ReadyForQuery:
+				messages = append(messages, &pgproto3.ReadyForQuery{TxStatus: message.TxStatus})
+			}
+		}
+	}
+
+	messages := answer("SELECT gatewayd_cache_invalidate('users')")
+	assert.Equal(t, []pgproto3.BackendMessage{
+		&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{
+			Name: []byte(InvalidateFunction), DataTypeOID: OIDInt8, DataTypeSize: 8, TypeModifier: -1,
+		}}},
+		&pgproto3.DataRow{Values: [][]byte{[]byte("2")}},
+		&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")},
+		&pgproto3.ReadyForQuery{TxStatus: 'T'},
+	}, messages)
+	assert.Equal(t, int64(0), redisClient.Exists(ctx, p.responseKey(users)).Val())
+	assert.Equal(t, int64(1), redisClient.Exists(ctx, p.responseKey(posts)).Val())
+
+	// Invalid arguments are answered with an error.
+	messages = answer("SELECT gatewayd_cache_invalidate('*')")
+	assert.Equal(t, []pgproto3.BackendMessage{
+		&pgproto3.ErrorResponse{Code: invalidParameterValue},
+		&pgproto3.ReadyForQuery{TxStatus: 'T'},
+	}, messages)
+	assert.Equal(t, int64(1), redisClient.Exists(ctx, p.responseKey(posts)).Val())
+
+	messages = answer("SELECT gatewayd_cache_invalidate_tag('tenant:42')")
+	assert.Equal(t, &pgproto3.DataRow{Values: [][]byte{[]byte("0")}}, messages[1])
+}
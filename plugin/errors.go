@@ -2,4 +2,22 @@ package plugin
 
 import "errors"
 
-var ErrInvalidAddressPortPair = errors.New("invalid address:port pair")
+var (
+	ErrInvalidAddressPortPair = errors.New("invalid address:port pair")
+	ErrNotQueryMessage        = errors.New("request is not a simple query message")
+	ErrEmptyQuery             = errors.New("query contains no statements")
+	ErrInvalidServerGroup     = errors.New("invalid server group, expected address=cluster")
+	ErrInvalidSessionID       = errors.New("invalid session ID, expected network:address")
+	ErrUnnamedClientAddress   = errors.New("client has no distinguishable address, e.g. an unnamed Unix domain socket")
+	ErrInvalidOverflowPolicy  = errors.New(
+		"invalid overflow policy, expected block, drop-newest or drop-oldest")
+	ErrCircuitOpen        = errors.New("circuit breaker is open, bypassing the cache")
+	ErrInvalidFingerprint = errors.New("invalid fingerprint, expected a hex encoded SHA-256 hash")
+	ErrInvalidSetting     = errors.New("invalid setting, expected name=value")
+	ErrInvalidTTLRebase   = errors.New("invalid TTL rebase, expected keep, elapsed or expiry")
+	ErrInvalidSnapshot    = errors.New("invalid snapshot")
+	ErrInvalidTag         = errors.New("invalid tag, expected letters, digits, '_', '.', ':' or '-'")
+	ErrInvalidTable       = errors.New("invalid table, expected a name without glob patterns")
+	ErrInvalidWarmupEntry = errors.New(
+		`invalid warm-up entry, expected {"database": ..., "user": ..., "sql": ...}`)
+)
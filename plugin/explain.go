@@ -0,0 +1,280 @@
+package plugin
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/gatewayd-io/gatewayd-plugin-sdk/databases/postgres"
+	"github.com/jackc/pgx/v5/pgproto3"
+	pgQuery "github.com/wasilibs/go-pgquery"
+)
+
+// Statement classes.
+const (
+	SelectStatement      = "select"
+	DMLStatement         = "dml"
+	DDLStatement         = "ddl"
+	TransactionStatement = "transaction"
+	UtilityStatement     = "utility"
+)
+
+// Explain rules, which describe how the cache handles a query.
+const (
+	DatabaseRequiredRule   = "database-required"
+	ParseErrorRule         = "parse-error"
+	DateTimeFunctionRule   = "date-time-function"
+	CacheableResponseRule  = "cacheable-response"
+	InvalidatesTablesRule  = "invalidates-tables"
+	NormalizedCacheKeyRule = "normalized-cache-key"
+	RawCacheKeyRule        = "raw-cache-key"
+	SharedSessionsRule     = "shared-sessions"
+	CacheBypassedRule      = "cache-bypassed"
+	ShadowModeRule         = "shadow-mode"
+	TaggedResponseRule     = "tagged-response"
+	InvalidatesTagsRule    = "invalidates-tags"
+	ControlStatementRule   = "control-statement"
+)
+
+// ExplainRequest is a query to explain, along with the context of the session sending it.
+type ExplainRequest struct {
+	Server   string `json:"server"`
+	Database string `json:"database,omitempty"`
+	User     string `json:"user,omitempty"`
+	// Session is the ID of a registered session, e.g. tcp:127.0.0.1:54321,
+	// whose database and user are used if they are not set.
+	Session  string            `json:"session,omitempty"`
+	Settings map[string]string `json:"settings,omitempty"`
+	SQL      string            `json:"sql"`
+}
+
+// ExplainRule is a rule that applies to a query.
+type ExplainRule struct {
+	Name   string `json:"name"`
+	Detail string `json:"detail"`
+}
+
+// Explanation describes how the cache handles a query.
+type Explanation struct {
+	Server            string            `json:"server"`
+	Cluster           string            `json:"cluster"`
+	Database          string            `json:"database"`
+	User              string            `json:"user,omitempty"`
+	Settings          map[string]string `json:"settings,omitempty"`
+	CacheKey          string            `json:"cacheKey"`
+	Fingerprint       string            `json:"fingerprint,omitempty"`
+	Statements        []string          `json:"statements"`
+	Tables            []string          `json:"tables"`
+	Tags              []string          `json:"tags"`
+	VolatileFunctions []string          `json:"volatileFunctions"`
+	Cacheable         bool              `json:"cacheable"`
+	Invalidates       bool              `json:"invalidates"`
+	Rules             []ExplainRule     `json:"rules"`
+	// TTL is the expiry of the response when it is cached.
+	TTL string `json:"ttl"`
+	// Entry is the response currently cached for the query, if any.
+	Entry *LookupResult `json:"entry"`
+}
+
+func (e *Explanation) addRule(name, detail string) {
+	e.Rules = append(e.Rules, ExplainRule{Name: name, Detail: detail})
+}
+
+// Explain reports how the cache handles the query if it were sent as a simple
+// query by a client of the session: its cache key, the rules that decide whether
+// its response is cached and whether it invalidates cached responses, and the
+// response currently cached for it. The rules are the ones of the hooks.
+func (p *Plugin) Explain(ctx context.Context, req ExplainRequest) (*Explanation, error) {
+	if req.Session != "" && p.Sessions != nil {
+		if session, ok := p.Sessions.Get(req.Session); ok {
+			req.Database = cmp.Or(req.Database, session.Database)
+			req.User = cmp.Or(req.User, session.User)
+		}
+	}
+	if req.Database == "" {
+		req.Database = p.DefaultDBName
+	}
+
+	upperQuery := strings.ToUpper(req.SQL)
+	explanation := &Explanation{
+		Server:            req.Server,
+		Cluster:           p.getClusterName(req.Server),
+		Database:          req.Database,
+		User:              req.User,
+		Settings:          req.Settings,
+		Statements:        []string{},
+		Tables:            []string{},
+		Tags:              []string{},
+		VolatileFunctions: dateTimeFunctions(upperQuery),
+		Invalidates:       invalidatesTables(upperQuery),
+		TTL:               formatTTL(p.Expiry),
+	}
+	if explanation.VolatileFunctions == nil {
+		explanation.VolatileFunctions = []string{}
+	}
+
+	bypassed := p.CircuitBreaker != nil && p.CircuitBreaker.State() == BreakerOpen
+	if bypassed {
+		explanation.addRule(CacheBypassedRule,
+			"Redis is failing, so the cache is bypassed until the circuit breaker closes")
+	}
+
+	if p.ShadowMode {
+		explanation.addRule(ShadowModeRule,
+			"The plugin is in shadow mode, so cached responses are compared with "+
+				"the responses of the server but never served")
+	}
+
+	if req.Database == "" {
+		explanation.addRule(DatabaseRequiredRule,
+			"The database of the session is unknown and no default database is set, so the cache is skipped")
+	}
+
+	if statements, err := classifyStatements(req.SQL); err != nil {
+		explanation.addRule(ParseErrorRule,
+			"The query cannot be parsed, so its tables are unknown and "+
+				"writes to them don't invalidate its cached response: "+err.Error())
+	} else {
+		explanation.Statements = statements
+		if tables, err := postgres.GetTablesFromQuery(req.SQL); err == nil && tables != nil {
+			explanation.Tables = tables
+		}
+	}
+
+	if len(explanation.VolatileFunctions) > 0 {
+		explanation.addRule(DateTimeFunctionRule,
+			"The query calls date/time functions, so its response is never cached")
+	} else if req.Database != "" {
+		explanation.Cacheable = true
+		if p.EmptyResultExpiry > 0 {
+			explanation.addRule(CacheableResponseRule,
+				"The response is cached if it has no error, for "+formatTTL(p.EmptyResultExpiry)+
+					" if it has no rows")
+		} else {
+			explanation.addRule(CacheableResponseRule,
+				"The response is cached if it has rows and no error")
+		}
+	}
+
+	if statement := parseControlStatement(req.SQL); statement != nil {
+		explanation.Cacheable = false
+		explanation.addRule(ControlStatementRule,
+			"The query calls "+statement.function+", so it is answered by the plugin with the number "+
+				"of deleted keys and never reaches the server")
+	}
+
+	if hints := parseHints(req.SQL); len(hints.invalidateTags) > 0 {
+		explanation.Cacheable = false
+		explanation.addRule(InvalidatesTagsRule,
+			"The query has an invalidate hint, so it invalidates the cached responses tagged with "+
+				strings.Join(hints.invalidateTags, ", ")+", is always sent to the server and is never cached")
+	} else if len(hints.tags) > 0 {
+		explanation.Tags = hints.tags
+		explanation.addRule(TaggedResponseRule,
+			"The query has a cache hint, so its cached response is also invalidated by the tags")
+	}
+
+	if explanation.Invalidates {
+		explanation.addRule(InvalidatesTablesRule,
+			"The query doesn't start with SELECT, so it invalidates the cached responses of its tables")
+	}
+
+	request, err := (&pgproto3.Query{String: req.SQL}).Encode(nil)
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := p.getCacheKey(req.Server, req.Database, request)
+	explanation.CacheKey = p.responseKey(cacheKey)
+
+	_, _, key, _ := parseCacheKey(cacheKey)
+	explanation.Fingerprint = requestFingerprint(key)
+	if isFingerprint(key) {
+		explanation.addRule(NormalizedCacheKeyRule,
+			"The cache key is the fingerprint of the normalized query, so it is shared by "+
+				"queries that differ only in comments, whitespace or keyword case")
+	} else {
+		explanation.addRule(RawCacheKeyRule,
+			"The cache key is the raw query, so queries must match byte for byte")
+	}
+
+	if req.User != "" || len(req.Settings) > 0 {
+		explanation.addRule(SharedSessionsRule,
+			"The cache key doesn't depend on the user or on session settings, e.g. search_path, "+
+				"so all the sessions of the database share the cached response")
+	}
+
+	// Redis is not queried while the circuit breaker is open.
+	if !bypassed {
+		if explanation.Entry, err = p.Lookup(ctx, req.Server, req.Database, req.SQL); err != nil {
+			return nil, err
+		}
+	}
+
+	return explanation, nil
+}
+
+// ParseSettings parses session settings given as name=value pairs,
+// e.g. search_path=public.
+func ParseSettings(pairs []string) (map[string]string, error) {
+	settings := map[string]string{}
+	for _, pair := range pairs {
+		name, value, found := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, ErrInvalidSetting
+		}
+		settings[name] = strings.TrimSpace(value)
+	}
+
+	return settings, nil
+}
+
+// parseTree is the parse tree of a query, as returned by ParseToJSON, with the
+// node type of each statement.
+type parseTree struct {
+	Stmts []struct {
+		Stmt map[string]json.RawMessage `json:"stmt"`
+	} `json:"stmts"`
+}
+
+// classifyStatements returns the class of each statement of the query.
+func classifyStatements(query string) ([]string, error) {
+	tree, err := pgQuery.ParseToJSON(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed parseTree
+	if err := json.Unmarshal([]byte(tree), &parsed); err != nil {
+		return nil, err
+	}
+
+	statements := make([]string, 0, len(parsed.Stmts))
+	for _, stmt := range parsed.Stmts {
+		// Each statement is a node with a single field named after its type.
+		nodeType := ""
+		for name := range stmt.Stmt {
+			nodeType = name
+		}
+		statements = append(statements, classifyStatement(nodeType))
+	}
+	return statements, nil
+}
+
+// classifyStatement returns the class of a statement by its node type.
+func classifyStatement(nodeType string) string {
+	switch nodeType {
+	case "SelectStmt":
+		return SelectStatement
+	case "InsertStmt", "UpdateStmt", "DeleteStmt", "MergeStmt":
+		return DMLStatement
+	case "CreateStmt", "CreateTableAsStmt", "ViewStmt", "IndexStmt", "AlterTableStmt",
+		"RenameStmt", "DropStmt", "TruncateStmt", "CreateSchemaStmt":
+		return DDLStatement
+	case "TransactionStmt":
+		return TransactionStatement
+	default:
+		return UtilityStatement
+	}
+}
@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ruleNames(explanation *Explanation) []string {
+	names := make([]string, 0, len(explanation.Rules))
+	for _, rule := range explanation.Rules {
+		names = append(names, rule.Name)
+	}
+	return names
+}
+
+func TestExplain(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	ctx := context.Background()
+
+	cacheKey := populateCache(
+		t, p, redisClient, "localhost:5432", "postgres", "SELECT * FROM users", "users")
+
+	explanation, err := p.Explain(ctx, ExplainRequest{
+		Server:   "localhost:5432",
+		Database: "postgres",
+		SQL:      "SELECT * FROM users",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, p.responseKey(cacheKey), explanation.CacheKey)
+	assert.Len(t, explanation.Fingerprint, FingerprintLength)
+	assert.Equal(t, []string{SelectStatement}, explanation.Statements)
+	assert.Equal(t, []string{"users"}, explanation.Tables)
+	assert.Empty(t, explanation.VolatileFunctions)
+	assert.True(t, explanation.Cacheable)
+	assert.False(t, explanation.Invalidates)
+	assert.Equal(t, []string{CacheableResponseRule, RawCacheKeyRule}, ruleNames(explanation))
+	assert.Equal(t, "1h0m0s", explanation.TTL)
+	assert.True(t, explanation.Entry.Hit)
+
+	// The database and the user are taken from the session.
+	p.Sessions.Register("tcp:127.0.0.1:54321", Session{Database: "shop", User: "alice"})
+	explanation, err = p.Explain(ctx, ExplainRequest{
+		Server:   "localhost:5432",
+		Session:  "tcp:127.0.0.1:54321",
+		Settings: map[string]string{"search_path": "public"},
+		SQL:      "UPDATE orders SET paid = now() WHERE id = 1",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "shop", explanation.Database)
+	assert.Equal(t, "alice", explanation.User)
+	assert.Equal(t, []string{DMLStatement}, explanation.Statements)
+	assert.Equal(t, []string{"orders"}, explanation.Tables)
+	assert.Equal(t, []string{"NOW"}, explanation.VolatileFunctions)
+	assert.False(t, explanation.Cacheable)
+	assert.True(t, explanation.Invalidates)
+	assert.Equal(t, []string{
+		DateTimeFunctionRule, InvalidatesTablesRule, RawCacheKeyRule, SharedSessionsRule,
+	}, ruleNames(explanation))
+	assert.False(t, explanation.Entry.Hit)
+
+	explanation, err = p.Explain(ctx, ExplainRequest{
+		Server: "localhost:5432",
+		SQL:    "SELEC 1",
+	})
+	assert.Nil(t, err)
+	assert.Empty(t, explanation.Statements)
+	assert.Equal(t, []string{
+		DatabaseRequiredRule, ParseErrorRule, InvalidatesTablesRule, RawCacheKeyRule,
+	}, ruleNames(explanation))
+
+	explanation, err = p.Explain(ctx, ExplainRequest{
+		Server:   "localhost:5432",
+		Database: "postgres",
+		SQL:      "/* gatewayd:cache tag=tenant:42 */ SELECT gatewayd_cache_invalidate('users')",
+	})
+	assert.Nil(t, err)
+	assert.False(t, explanation.Cacheable)
+	assert.False(t, explanation.Invalidates)
+	assert.Equal(t, []string{"tenant:42"}, explanation.Tags)
+	assert.Equal(t, []string{
+		CacheableResponseRule, ControlStatementRule, TaggedResponseRule, RawCacheKeyRule,
+	}, ruleNames(explanation))
+}
+
+func TestParseSettings(t *testing.T) {
+	settings, err := ParseSettings([]string{"search_path = public", "timezone=UTC"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"search_path": "public", "timezone": "UTC"}, settings)
+
+	_, err = ParseSettings([]string{"search_path"})
+	assert.ErrorIs(t, err, ErrInvalidSetting)
+}
+
+func TestAdminExplain(t *testing.T) {
+	plugin, _ := newTestPlugin(t)
+	handler := plugin.Impl.AdminHandler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/explain?"+url.Values{
+		"server":   {"localhost:5432"},
+		"database": {"postgres"},
+		"setting":  {"search_path=public"},
+		"sql":      {"BEGIN"},
+	}.Encode(), nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var explanation Explanation
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &explanation))
+	assert.Equal(t, []string{TransactionStatement}, explanation.Statements)
+	assert.Equal(t, map[string]string{"search_path": "public"}, explanation.Settings)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(
+		http.MethodGet, "/explain?server=localhost:5432&sql=SELECT+1&setting=invalid", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestClassifyStatements(t *testing.T) {
+	statements, err := classifyStatements(
+		"SELECT 1; UPDATE users SET id = 1; CREATE TABLE t (id int); BEGIN; VACUUM")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		SelectStatement, DMLStatement, DDLStatement, TransactionStatement, UtilityStatement,
+	}, statements)
+
+	_, err = classifyStatements("SELEC 1")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,54 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/gatewayd-io/gatewayd-plugin-sdk/databases/postgres"
+	pgQuery "github.com/wasilibs/go-pgquery"
+)
+
+// QueryMessageType is the PostgreSQL wire protocol type of a simple query message.
+const QueryMessageType = 'Q'
+
+// normalizeQuery parses the query and deparses it back into its canonical text.
+// Comments, redundant whitespace and keyword casing are dropped, while literals
+// are kept as is, so queries that differ only in formatting normalize to the same text.
+func normalizeQuery(query string) (string, error) {
+	tree, err := pgQuery.Parse(query)
+	if err != nil {
+		return "", err
+	}
+
+	if len(tree.GetStmts()) == 0 {
+		return "", ErrEmptyQuery
+	}
+
+	return pgQuery.Deparse(tree)
+}
+
+// fingerprintQuery returns the hex encoded SHA-256 hash of the normalized query.
+func fingerprintQuery(query string) (string, error) {
+	normalized, err := normalizeQuery(query)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// fingerprintRequest returns the fingerprint of the query in a simple query message.
+// Other message types cannot be normalized and are rejected.
+func fingerprintRequest(request []byte) (string, error) {
+	if len(request) < postgres.MinPgSQLMessageLength || request[0] != QueryMessageType {
+		return "", ErrNotQueryMessage
+	}
+
+	query, err := postgres.GetQueryFromRequest(request)
+	if err != nil {
+		return "", err
+	}
+
+	return fingerprintQuery(query)
+}
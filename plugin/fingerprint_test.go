@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"testing"
+
+	pgproto3 "github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_normalizeQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"lowercase keywords", "select * from users", "SELECT * FROM users"},
+		{"extra whitespace", "SELECT *  FROM\n\tusers", "SELECT * FROM users"},
+		{"trailing comment", "SELECT * FROM users -- list users", "SELECT * FROM users"},
+		{"block comment", "SELECT /* all */ * FROM users;", "SELECT * FROM users"},
+		{"literals are kept", "select * from users where name = 'Bob'", "SELECT * FROM users WHERE name = 'Bob'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalized, err := normalizeQuery(tt.query)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, normalized)
+		})
+	}
+}
+
+func Test_normalizeQuery_Fails(t *testing.T) {
+	_, err := normalizeQuery("SELEC * FROM")
+	assert.NotNil(t, err)
+
+	_, err = normalizeQuery("-- only a comment")
+	assert.ErrorIs(t, err, ErrEmptyQuery)
+}
+
+func Test_fingerprintQuery(t *testing.T) {
+	fingerprint, err := fingerprintQuery("select * from users")
+	assert.Nil(t, err)
+	assert.Len(t, fingerprint, 64)
+
+	other, err := fingerprintQuery("SELECT *  FROM users /* comment */")
+	assert.Nil(t, err)
+	assert.Equal(t, fingerprint, other)
+
+	// Different literals must never share a fingerprint.
+	withBob, err := fingerprintQuery("SELECT * FROM users WHERE name = 'Bob'")
+	assert.Nil(t, err)
+	withAlice, err := fingerprintQuery("SELECT * FROM users WHERE name = 'Alice'")
+	assert.Nil(t, err)
+	assert.NotEqual(t, withBob, withAlice)
+}
+
+func Test_fingerprintRequest(t *testing.T) {
+	query, request := testQueryRequest()
+	fingerprint, err := fingerprintRequest(request)
+	assert.Nil(t, err)
+	expected, err := fingerprintQuery(query)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, fingerprint)
+
+	parse, _ := (&pgproto3.Parse{Query: query}).Encode(nil)
+	_, err = fingerprintRequest(parse)
+	assert.ErrorIs(t, err, ErrNotQueryMessage)
+
+	_, err = fingerprintRequest(testStartupRequest())
+	assert.ErrorIs(t, err, ErrNotQueryMessage)
+}
@@ -0,0 +1,158 @@
+package plugin
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Hints are comments in queries that direct the cache, e.g.
+//
+//	/* gatewayd:cache tag=tenant:42 */ SELECT * FROM orders WHERE tenant_id = 42
+//	/* gatewayd:invalidate tag=tenant:42 */ SELECT 1
+//
+// A hint has a name and arguments, each given as name=value. Tags are given as
+// tag=<tag>, more than once or comma separated.
+const (
+	HintPrefix = "gatewayd:"
+	// CacheHint tags the cached response of the query.
+	CacheHint = "cache"
+	// InvalidateHint invalidates the cached responses of the tags. The query is
+	// still sent to the server, but its response is never cached.
+	InvalidateHint = "invalidate"
+	// TagHintArgument is the argument of a hint that names a tag.
+	TagHintArgument = "tag"
+)
+
+var (
+	hintPattern = regexp.MustCompile(`/\*\s*gatewayd:(\w+)([^*]*)\*/`)
+	// Tags are written in the keys of the tag index, so they can't contain braces,
+	// which separate them from the cache key, or glob patterns.
+	tagPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+)
+
+// queryHints are the hints of a query.
+type queryHints struct {
+	// tags are the tags of the cached response.
+	tags []string
+	// invalidateTags are the tags whose cached responses the query invalidates.
+	invalidateTags []string
+}
+
+// isValidTag checks whether the tag can be indexed.
+func isValidTag(tag string) bool {
+	return tagPattern.MatchString(tag)
+}
+
+// parseHints returns the hints in the comments of the query. Unknown hints and
+// invalid tags are ignored, like any other comment.
+func parseHints(sql string) queryHints {
+	var hints queryHints
+	if !strings.Contains(sql, HintPrefix) {
+		return hints
+	}
+
+	for _, match := range hintPattern.FindAllStringSubmatch(sql, -1) {
+		var tags []string
+		for _, argument := range strings.Fields(match[2]) {
+			name, value, _ := strings.Cut(argument, "=")
+			if name != TagHintArgument {
+				continue
+			}
+			for _, tag := range strings.Split(value, ",") {
+				if isValidTag(tag) {
+					tags = append(tags, tag)
+				}
+			}
+		}
+
+		switch match[1] {
+		case CacheHint:
+			hints.tags = append(hints.tags, tags...)
+		case InvalidateHint:
+			hints.invalidateTags = append(hints.invalidateTags, tags...)
+		}
+	}
+
+	slices.Sort(hints.tags)
+	hints.tags = slices.Compact(hints.tags)
+	slices.Sort(hints.invalidateTags)
+	hints.invalidateTags = slices.Compact(hints.invalidateTags)
+	return hints
+}
+
+// trimLeadingComments removes the comments and whitespace at the start of the
+// query, so that hints don't hide the statement from prefix checks.
+func trimLeadingComments(sql string) string {
+	for {
+		sql = strings.TrimLeft(sql, " \t\r\n")
+		switch {
+		case strings.HasPrefix(sql, "/*"):
+			end := strings.Index(sql, "*/")
+			if end < 0 {
+				return sql
+			}
+			sql = sql[end+2:]
+		case strings.HasPrefix(sql, "--"):
+			end := strings.IndexByte(sql, '\n')
+			if end < 0 {
+				return ""
+			}
+			sql = sql[end+1:]
+		default:
+			return sql
+		}
+	}
+}
+
+// InvalidateTag deletes all the cached responses tagged with the tag.
+func (p *Plugin) InvalidateTag(ctx context.Context, tag string) (int, error) {
+	if !isValidTag(tag) {
+		return 0, ErrInvalidTag
+	}
+
+	return p.invalidateTags(ctx, []string{tag})
+}
+
+// invalidateTags deletes the cached responses tagged with any of the tags, along
+// with their tag index keys, and returns the number of deleted keys. The table
+// index keys of the responses are left to expire or to be purged as orphans.
+func (p *Plugin) invalidateTags(ctx context.Context, tags []string) (deleted int, err error) {
+	ctx, span := p.startSpan(ctx, "cache.invalidate", CacheTagsAttribute.StringSlice(tags))
+	defer func() {
+		span.SetAttributes(CacheDeletedAttribute.Int(deleted))
+		endSpan(span, err)
+	}()
+
+	for i, tag := range tags {
+		// Cache keys start with the cluster in braces, which tags can't contain,
+		// so the pattern doesn't match tags that only start with the tag.
+		var pipelineErr error
+		err = p.scanKeys(ctx, p.tagIndexKey(tag, "{*"), func(keys []string) {
+			pipeline := p.RedisClient.TxPipeline()
+			for _, indexKey := range keys {
+				if _, cacheKey, ok := p.parseTagIndexKey(indexKey); ok {
+					pipeline.Del(ctx, p.responseKey(cacheKey))
+					pipeline.Del(ctx, indexKey)
+				}
+			}
+			count, err := p.execDeletePipeline(ctx, pipeline)
+			deleted += count
+			if err != nil {
+				pipelineErr = err
+			}
+			CacheTagInvalidationsCounter.Add(float64(len(keys)))
+		})
+		if err == nil {
+			err = pipelineErr
+		}
+		if err != nil {
+			p.recordFailedInvalidation(nil, tags[i:])
+			return deleted, err
+		}
+	}
+
+	p.Logger.Debug("Invalidated cached responses by tag", "tags", tags, "deleted", deleted)
+	return deleted, nil
+}
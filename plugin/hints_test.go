@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	sdkAct "github.com/gatewayd-io/gatewayd-plugin-sdk/act"
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseHints(t *testing.T) {
+	hints := parseHints("/* gatewayd:cache tag=tenant:42,orders tag=tenant:42 */ SELECT * FROM orders " +
+		"/* gatewayd:cache tag=invalid{tag} other=value */ /* gatewayd:unknown tag=x */")
+	assert.Equal(t, []string{"orders", "tenant:42"}, hints.tags)
+	assert.Empty(t, hints.invalidateTags)
+
+	hints = parseHints("/*gatewayd:invalidate tag=tenant:42*/ SELECT 1")
+	assert.Empty(t, hints.tags)
+	assert.Equal(t, []string{"tenant:42"}, hints.invalidateTags)
+
+	assert.Equal(t, queryHints{}, parseHints("SELECT 1 /* a comment */"))
+}
+
+func Test_invalidatesTables_LeadingComments(t *testing.T) {
+	assert.False(t, invalidatesTables("/* GATEWAYD:CACHE TAG=A */\n-- A COMMENT\nSELECT * FROM USERS"))
+	assert.True(t, invalidatesTables("/* APP */ INSERT INTO USERS VALUES (1)"))
+}
+
+func TestTagInvalidation(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	p.DefaultDBName = "postgres"
+	ctx := context.Background()
+
+	request := func(sql string) []byte {
+		request, err := (&pgproto3.Query{String: sql}).Encode(nil)
+		assert.Nil(t, err)
+		return request
+	}
+	server := map[string]interface{}{"remote": "localhost:5432"}
+
+	// The responses are cached along with the tags of their cache hint.
+	cache := func(sql string) string {
+		serverResponse, err := v1.NewStruct(map[string]interface{}{
+			"request":  request(sql),
+			"response": encodeResponse(t, "a"),
+			"server":   server,
+		})
+		assert.Nil(t, err)
+		write := p.prepareCacheWrite(ctx, serverResponse)
+		assert.NotNil(t, write)
+		p.writeCache(ctx, write)
+		return write.cacheKey
+	}
+	tenant42 := cache("/* gatewayd:cache tag=tenant:42 */ SELECT * FROM orders WHERE tenant_id = 42")
+	tenant4 := cache("/* gatewayd:cache tag=tenant:4 */ SELECT * FROM orders WHERE tenant_id = 4")
+	assert.Equal(t, int64(1), redisClient.Exists(ctx, p.tagIndexKey("tenant:42", tenant42)).Val())
+	assert.Equal(t, int64(1), redisClient.Exists(ctx, p.tableIndexKey("orders", tenant42)).Val())
+
+	// The invalidation statement is sent to the server, and its response is never cached.
+	invalidation := "/* gatewayd:invalidate tag=tenant:4 */ SELECT 1"
+	req, err := v1.NewStruct(map[string]interface{}{
+		"request": request(invalidation),
+		"server":  server,
+	})
+	assert.Nil(t, err)
+	result, err := p.OnTrafficFromClient(ctx, req)
+	assert.Nil(t, err)
+	assert.NotContains(t, result.GetFields(), sdkAct.Signals)
+
+	serverResponse, err := v1.NewStruct(map[string]interface{}{
+		"request":  request(invalidation),
+		"response": encodeResponse(t, "1"),
+		"server":   server,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, p.prepareCacheWrite(ctx, serverResponse))
+
+	// Only the responses of the tag are invalidated, not the ones of tags it is a prefix of.
+	assert.Equal(t, int64(0), redisClient.Exists(ctx,
+		p.responseKey(tenant4), p.tagIndexKey("tenant:4", tenant4)).Val())
+	assert.Equal(t, int64(1), redisClient.Exists(ctx, p.responseKey(tenant42)).Val())
+
+	// The orphaned table index key is purged.
+	deleted, err := p.PurgeOrphanedIndexKeys(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, deleted)
+
+	deleted, err = p.InvalidateTag(ctx, "tenant:42")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, int64(0), redisClient.Exists(ctx, p.responseKey(tenant42)).Val())
+
+	_, err = p.InvalidateTag(ctx, "tenant:*")
+	assert.ErrorIs(t, err, ErrInvalidTag)
+}
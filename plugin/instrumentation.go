@@ -0,0 +1,158 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	goRedis "github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultMetricsLabelLimit is the number of distinct values of a metric label if none is configured.
+	DefaultMetricsLabelLimit = 100
+	// OtherLabelValue replaces the values of a metric label once its limit is reached.
+	OtherLabelValue = "other"
+	// PipelineCommand is the command label of Redis pipelines and transactions.
+	PipelineCommand = "pipeline"
+
+	// Labels of HookDurationHistogram.
+	OnTrafficFromClientHook = "on_traffic_from_client"
+	OnTrafficFromServerHook = "on_traffic_from_server"
+	UpdateCacheHook         = "update_cache"
+	CacheWriteHook          = "cache_write"
+)
+
+// LabelGuard limits the number of distinct values of metric labels, so that
+// databases and tables created on the fly don't blow up the number of series.
+type LabelGuard struct {
+	mutex  sync.Mutex
+	values map[string]map[string]struct{}
+}
+
+// NewLabelGuard returns a label guard that hasn't seen any value yet.
+func NewLabelGuard() *LabelGuard {
+	return &LabelGuard{values: map[string]map[string]struct{}{}}
+}
+
+// Value returns the value if it has already been seen for the label or if the label
+// has less than limit distinct values. Otherwise, it returns OtherLabelValue.
+func (g *LabelGuard) Value(label, value string, limit int) string {
+	if limit <= 0 {
+		limit = DefaultMetricsLabelLimit
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	seen, ok := g.values[label]
+	if !ok {
+		seen = map[string]struct{}{}
+		g.values[label] = seen
+	}
+
+	if _, ok := seen[value]; ok {
+		return value
+	}
+	if len(seen) >= limit {
+		return OtherLabelValue
+	}
+
+	seen[value] = struct{}{}
+	return value
+}
+
+// metricLabels returns the guarded database and table labels of a metric.
+func (p *Plugin) metricLabels(database, table string) []string {
+	if p.metricLabelGuard == nil {
+		return []string{database, table}
+	}
+
+	return []string{
+		p.metricLabelGuard.Value("database", database, p.MetricsLabelLimit),
+		p.metricLabelGuard.Value("table", table, p.MetricsLabelLimit),
+	}
+}
+
+// countPerTable increments the counter once per table. Responses of queries
+// without tables, or whose tables are not known, are counted with an empty table.
+func (p *Plugin) countPerTable(counter *prometheus.CounterVec, database string, tables []string) {
+	if len(tables) == 0 {
+		counter.WithLabelValues(p.metricLabels(database, "")...).Inc()
+		return
+	}
+
+	for _, table := range tables {
+		counter.WithLabelValues(p.metricLabels(database, table)...).Inc()
+	}
+}
+
+// RedisMetricsHook observes the duration of the Redis commands. It should be
+// added after the circuit breaker, so that rejected commands are not observed.
+type RedisMetricsHook struct{}
+
+var _ goRedis.Hook = RedisMetricsHook{}
+
+func (RedisMetricsHook) DialHook(next goRedis.DialHook) goRedis.DialHook {
+	return next
+}
+
+func (RedisMetricsHook) ProcessHook(next goRedis.ProcessHook) goRedis.ProcessHook {
+	return func(ctx context.Context, cmd goRedis.Cmder) error {
+		defer prometheus.NewTimer(RedisCommandDurationHistogram.WithLabelValues(cmd.Name())).ObserveDuration()
+		return next(ctx, cmd)
+	}
+}
+
+func (RedisMetricsHook) ProcessPipelineHook(next goRedis.ProcessPipelineHook) goRedis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []goRedis.Cmder) error {
+		defer prometheus.NewTimer(RedisCommandDurationHistogram.WithLabelValues(PipelineCommand)).ObserveDuration()
+		return next(ctx, cmds)
+	}
+}
+
+// CacheSizeSampler periodically samples the number and the size of the cached
+// responses per database into CachedEntriesGauge and CachedBytesGauge. This
+// scans all the cached responses, so the interval should not be too short.
+func (p *Plugin) CacheSizeSampler(ctx context.Context, interval time.Duration) {
+	if err := p.schedule(interval, time.Now(), func() {
+		p.sampleCacheSize(ctx)
+	}); err != nil {
+		p.Logger.Error("Failed to start cache size sampler",
+			"error", err, "interval", interval.String())
+		return
+	}
+
+	p.Logger.Debug("Started cache size sampler", "interval", interval.String())
+}
+
+// sampleCacheSize samples the number and the size of the cached responses per database.
+func (p *Plugin) sampleCacheSize(ctx context.Context) {
+	if p.cacheBypassed() {
+		return
+	}
+
+	stats, err := p.Stats(ctx)
+	if err != nil {
+		p.Logger.Debug("Failed to sample the size of the cache", "error", err)
+		return
+	}
+
+	// Databases over the label limit are summed up.
+	entries := map[string]float64{}
+	bytes := map[string]float64{}
+	for database, keyStats := range stats.Databases {
+		label := p.metricLabels(database, "")[0]
+		entries[label] += float64(keyStats.Keys)
+		bytes[label] += float64(keyStats.Bytes)
+	}
+
+	// Databases without cached responses anymore are removed.
+	CachedEntriesGauge.Reset()
+	CachedBytesGauge.Reset()
+	for database := range entries {
+		CachedEntriesGauge.WithLabelValues(database).Set(entries[database])
+		CachedBytesGauge.WithLabelValues(database).Set(bytes[database])
+	}
+}
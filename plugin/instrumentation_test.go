@@ -0,0 +1,88 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelGuard(t *testing.T) {
+	guard := NewLabelGuard()
+	assert.Equal(t, "users", guard.Value("table", "users", 2))
+	assert.Equal(t, "posts", guard.Value("table", "posts", 2))
+	// The limit is reached, so new values are replaced.
+	assert.Equal(t, OtherLabelValue, guard.Value("table", "comments", 2))
+	// Values that were already seen are kept.
+	assert.Equal(t, "users", guard.Value("table", "users", 2))
+	// Each label has its own limit.
+	assert.Equal(t, "comments", guard.Value("database", "comments", 2))
+}
+
+func TestLabeledCacheMetrics(t *testing.T) {
+	p, _ := newTestPlugin(t)
+	p.Impl.MetricsLabelLimit = 2
+	ctx := context.Background()
+
+	p.Impl.writeCache(ctx, &cacheWrite{
+		cacheKey: "{localhost:5432}:labeled:SELECT * FROM users, posts",
+		response: []byte("response"),
+		tables:   []string{"users", "posts"},
+	})
+	p.Impl.writeCache(ctx, &cacheWrite{
+		cacheKey: "{localhost:5432}:labeled:SELECT * FROM comments",
+		response: []byte("response"),
+		tables:   []string{"comments"},
+	})
+
+	assert.InDelta(t, 1, testutil.ToFloat64(
+		CacheTableSetsCounter.WithLabelValues("labeled", "users")), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(
+		CacheTableSetsCounter.WithLabelValues("labeled", "posts")), 0)
+	// The tables over the limit share a label.
+	assert.InDelta(t, 1, testutil.ToFloat64(
+		CacheTableSetsCounter.WithLabelValues("labeled", OtherLabelValue)), 0)
+
+	assert.Equal(t, 2, p.Impl.InvalidateTable(ctx, "users"))
+	assert.InDelta(t, 1, testutil.ToFloat64(
+		CacheTableInvalidationsCounter.WithLabelValues("labeled", "users")), 0)
+
+	p.Impl.sampleCacheSize(ctx)
+	assert.InDelta(t, 1, testutil.ToFloat64(CachedEntriesGauge.WithLabelValues("labeled")), 0)
+	assert.InDelta(t, len("response"), testutil.ToFloat64(CachedBytesGauge.WithLabelValues("labeled")), 0)
+}
+
+// observations returns the number of observations of the histogram.
+func observations(t *testing.T, observer prometheus.Observer) uint64 {
+	t.Helper()
+	histogram, ok := observer.(prometheus.Histogram)
+	assert.True(t, ok)
+
+	metric := &dto.Metric{}
+	assert.Nil(t, histogram.Write(metric))
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestRedisMetricsHook(t *testing.T) {
+	_, redisClient := newTestPlugin(t)
+	ctx := context.Background()
+	// The connection handshake is not observed.
+	assert.Nil(t, redisClient.Ping(ctx).Err())
+	redisClient.AddHook(RedisMetricsHook{})
+
+	gets := observations(t, RedisCommandDurationHistogram.WithLabelValues("get"))
+	pipelines := observations(t, RedisCommandDurationHistogram.WithLabelValues(PipelineCommand))
+
+	redisClient.Get(ctx, "metrics-hook")
+	pipeline := redisClient.TxPipeline()
+	pipeline.Set(ctx, "metrics-hook", "value", 0)
+	_, err := pipeline.Exec(ctx)
+	assert.Nil(t, err)
+
+	assert.Equal(t, gets+1, observations(t, RedisCommandDurationHistogram.WithLabelValues("get")))
+	assert.Equal(t, pipelines+1,
+		observations(t, RedisCommandDurationHistogram.WithLabelValues(PipelineCommand)))
+}
@@ -0,0 +1,104 @@
+package plugin
+
+import "strings"
+
+const (
+	// DefaultKeyPrefix is the prefix of all the keys owned by the plugin.
+	DefaultKeyPrefix = "gwc"
+	// KeySchemaVersion is the version of the key layout. Bumping it makes the plugin
+	// ignore all the keys written in the previous format, which then expire.
+	KeySchemaVersion = "v1"
+
+	SessionNamespace    = "session"
+	ResponseNamespace   = "resp"
+	TableIndexNamespace = "idx"
+	TagIndexNamespace   = "tag"
+
+	KeySeparator = ":"
+)
+
+// clusterKey wraps a cluster name in braces, so that the cluster can be told apart
+// from the rest of the cache key even though it usually contains a colon.
+func clusterKey(cluster string) string {
+	return "{" + cluster + "}"
+}
+
+// parseCacheKey splits a cache key into its cluster, database and request parts.
+func parseCacheKey(cacheKey string) (string, string, string, bool) {
+	if !strings.HasPrefix(cacheKey, "{") {
+		return "", "", "", false
+	}
+
+	end := strings.Index(cacheKey, "}"+KeySeparator)
+	if end < 0 {
+		return "", "", "", false
+	}
+
+	database, request, found := strings.Cut(cacheKey[end+2:], KeySeparator)
+	if !found {
+		return "", "", "", false
+	}
+
+	return cacheKey[1:end], database, request, true
+}
+
+// namespace returns the key prefix of a namespace, e.g. "gwc:v1:resp:".
+func (p *Plugin) namespace(name string) string {
+	prefix := p.KeyPrefix
+	if prefix == "" {
+		prefix = DefaultKeyPrefix
+	}
+
+	return strings.Join([]string{prefix, KeySchemaVersion, name}, KeySeparator) + KeySeparator
+}
+
+// sessionKey returns the key that stores the database of a client session.
+func (p *Plugin) sessionKey(client string) string {
+	return p.namespace(SessionNamespace) + client
+}
+
+// responseKey returns the key that stores the cached response of a cache key.
+func (p *Plugin) responseKey(cacheKey string) string {
+	return p.namespace(ResponseNamespace) + cacheKey
+}
+
+// tableIndexKey returns the key that marks a cache key as depending on a table.
+func (p *Plugin) tableIndexKey(table, cacheKey string) string {
+	return p.namespace(TableIndexNamespace) + table + KeySeparator + cacheKey
+}
+
+// cacheKeyFromTableIndexKey returns the cache key a table index key points to.
+func (p *Plugin) cacheKeyFromTableIndexKey(table, indexKey string) string {
+	return strings.TrimPrefix(indexKey, p.tableIndexKey(table, ""))
+}
+
+// tagIndexKey returns the key that marks a cache key as tagged with a tag.
+func (p *Plugin) tagIndexKey(tag, cacheKey string) string {
+	return p.namespace(TagIndexNamespace) + tag + KeySeparator + cacheKey
+}
+
+// parseTagIndexKey splits a tag index key into its tag and cache key. Tags may
+// contain colons, so they end where the cluster of the cache key starts.
+func (p *Plugin) parseTagIndexKey(indexKey string) (string, string, bool) {
+	rest, found := strings.CutPrefix(indexKey, p.namespace(TagIndexNamespace))
+	if !found {
+		return "", "", false
+	}
+
+	end := strings.Index(rest, KeySeparator+"{")
+	if end < 0 {
+		return "", "", false
+	}
+
+	return rest[:end], rest[end+1:], true
+}
+
+// parseTableIndexKey splits a table index key into its table and cache key.
+func (p *Plugin) parseTableIndexKey(indexKey string) (string, string, bool) {
+	rest, found := strings.CutPrefix(indexKey, p.namespace(TableIndexNamespace))
+	if !found {
+		return "", "", false
+	}
+
+	return strings.Cut(rest, KeySeparator)
+}
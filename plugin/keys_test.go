@@ -0,0 +1,64 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Keys_DefaultPrefix(t *testing.T) {
+	p := Plugin{}
+	assert.Equal(t, "gwc:v1:session:localhost:45320", p.sessionKey("localhost:45320"))
+	assert.Equal(t, "gwc:v1:resp:{localhost:5432}:postgres:Q", p.responseKey("{localhost:5432}:postgres:Q"))
+	assert.Equal(t,
+		"gwc:v1:idx:users:{localhost:5432}:postgres:Q",
+		p.tableIndexKey("users", "{localhost:5432}:postgres:Q"))
+}
+
+func Test_Keys_CustomPrefix(t *testing.T) {
+	p := Plugin{KeyPrefix: "cache"}
+	assert.Equal(t, "cache:v1:session:localhost:45320", p.sessionKey("localhost:45320"))
+	assert.Equal(t, "cache:v1:resp:{main}:postgres:Q", p.responseKey("{main}:postgres:Q"))
+	assert.Equal(t, "cache:v1:idx:users:*", p.tableIndexKey("users", "*"))
+}
+
+func Test_cacheKeyFromTableIndexKey(t *testing.T) {
+	p := Plugin{}
+	cacheKey := "{localhost:5432}:postgres:Q"
+	assert.Equal(t, cacheKey, p.cacheKeyFromTableIndexKey("users", p.tableIndexKey("users", cacheKey)))
+}
+
+func Test_parseCacheKey(t *testing.T) {
+	cluster, database, request, ok := parseCacheKey("{[::1]:5432}:postgres:Q:with:colons")
+	assert.True(t, ok)
+	assert.Equal(t, "[::1]:5432", cluster)
+	assert.Equal(t, "postgres", database)
+	assert.Equal(t, "Q:with:colons", request)
+
+	_, _, _, ok = parseCacheKey("localhost:5432:postgres:Q")
+	assert.False(t, ok)
+	_, _, _, ok = parseCacheKey("{localhost:5432}:postgres")
+	assert.False(t, ok)
+}
+
+func Test_parseTableIndexKey(t *testing.T) {
+	p := Plugin{}
+	table, cacheKey, ok := p.parseTableIndexKey("gwc:v1:idx:users:{localhost:5432}:postgres:Q")
+	assert.True(t, ok)
+	assert.Equal(t, "users", table)
+	assert.Equal(t, "{localhost:5432}:postgres:Q", cacheKey)
+
+	_, _, ok = p.parseTableIndexKey("gwc:v1:resp:{localhost:5432}:postgres:Q")
+	assert.False(t, ok)
+}
+
+func Test_parseTagIndexKey(t *testing.T) {
+	p := Plugin{}
+	tag, cacheKey, ok := p.parseTagIndexKey("gwc:v1:tag:tenant:42:{localhost:5432}:postgres:Q")
+	assert.True(t, ok)
+	assert.Equal(t, "tenant:42", tag)
+	assert.Equal(t, "{localhost:5432}:postgres:Q", cacheKey)
+
+	_, _, ok = p.parseTagIndexKey("gwc:v1:idx:users:{localhost:5432}:postgres:Q")
+	assert.False(t, ok)
+}
@@ -6,6 +6,9 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// LatencyBuckets are the buckets of the latency histograms, from 100µs to about 1.6s.
+var LatencyBuckets = prometheus.ExponentialBuckets(0.0001, 4, 8)
+
 var (
 	GetPluginConfigCounter = promauto.NewCounter(prometheus.CounterOpts{
 		Namespace: metrics.Namespace,
@@ -68,4 +71,165 @@ var (
 		Name:      "cache_errors_total",
 		Help:      "The total number of Redis operation errors",
 	})
+
+	UpdateCacheChannelDepthGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "update_cache_channel_depth",
+		Help:      "The number of server responses waiting to be cached",
+	})
+	CacheDroppedResponsesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cache_dropped_responses_total",
+		Help:      "The total number of server responses dropped because the cache update channel was full or closed",
+	}, []string{"policy"})
+
+	SessionsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "sessions",
+		Help:      "The number of client sessions held in memory",
+	})
+	CircuitBreakerStateGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "redis_circuit_breaker_state",
+		Help:      "The state of the Redis circuit breaker: 0 is closed, 1 is half-open and 2 is open",
+	})
+	CacheBypassedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cache_bypassed_total",
+		Help:      "The total number of hook calls that bypassed the cache because the circuit breaker was open",
+	})
+
+	HookDurationHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Name:      "hook_duration_seconds",
+		Help:      "The duration of the hooks and of caching each server response",
+		Buckets:   LatencyBuckets,
+	}, []string{"hook"})
+	RedisCommandDurationHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Name:      "redis_command_duration_seconds",
+		Help:      "The duration of the Redis commands and pipelines",
+		Buckets:   LatencyBuckets,
+	}, []string{"command"})
+
+	CacheTableHitsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cache_table_hits_total",
+		Help:      "The total number of cache hits per database and table",
+	}, []string{"database", "table"})
+	CacheTableMissesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cache_table_misses_total",
+		Help:      "The total number of cache misses per database and table",
+	}, []string{"database", "table"})
+	CacheTableSetsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cache_table_sets_total",
+		Help:      "The total number of cached responses per database and table",
+	}, []string{"database", "table"})
+	CacheShadowHitsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cache_shadow_hits_total",
+		Help:      "The total number of cache hits that were not served in shadow mode",
+	})
+	CacheTableShadowHitsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cache_table_shadow_hits_total",
+		Help:      "The total number of cache hits that were not served in shadow mode per database and table",
+	}, []string{"database", "table"})
+	CacheStaleResponsesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cache_stale_responses_total",
+		Help:      "The total number of stale cached responses found in shadow mode per database and table",
+	}, []string{"database", "table"})
+	CacheVerificationSamplesCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cache_verification_samples_total",
+		Help:      "The total number of cache hits sent to the server for verification",
+	})
+	CacheVerificationsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cache_verifications_total",
+		Help:      "The total number of verified cached responses by result",
+	}, []string{"result"})
+	CacheVerificationMismatchesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cache_verification_mismatches_total",
+		Help:      "The total number of stale cached responses found by verification per database and table",
+	}, []string{"database", "table"})
+	CacheTableInvalidationsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cache_table_invalidations_total",
+		Help:      "The total number of invalidated responses per database and table",
+	}, []string{"database", "table"})
+	CacheTagInvalidationsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cache_tag_invalidations_total",
+		Help:      "The total number of responses invalidated by tag",
+	})
+	CacheMissedInvalidationsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cache_missed_invalidations_total",
+		Help:      "The total number of writes recorded to be invalidated after the cache was bypassed",
+	})
+	ControlStatementsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cache_control_statements_total",
+		Help:      "The total number of control statements answered by the plugin per function and result",
+	}, []string{"function", "result"})
+
+	CachedEntriesGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cached_entries",
+		Help:      "The number of cached responses per database, as of the last sample",
+	}, []string{"database"})
+	CachedBytesGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "cached_bytes",
+		Help:      "The size of the cached responses per database, as of the last sample",
+	}, []string{"database"})
+
+	TopQueryHitsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "top_query_hits",
+		Help:      "The number of cache hits of the most looked up queries, as of the last sample",
+	}, []string{"database", "fingerprint"})
+	TopQueryMissesGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "top_query_misses",
+		Help:      "The number of cache misses of the most looked up queries, as of the last sample",
+	}, []string{"database", "fingerprint"})
+	TopQueryBytesServedGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "top_query_bytes_served",
+		Help:      "The size of the cached responses served for the most looked up queries, as of the last sample",
+	}, []string{"database", "fingerprint"})
+
+	WarmupQueriesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "warmup_queries_total",
+		Help:      "The total number of warm-up queries by result",
+	}, []string{"result"})
+	WarmupPendingQueriesGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "warmup_pending_queries",
+		Help:      "The number of warm-up queries that are not run yet",
+	})
+
+	RefreshAheadQueriesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "refresh_ahead_queries_total",
+		Help:      "The total number of queries re-run to refresh hot cached responses by result",
+	}, []string{"result"})
+	RefreshAheadDeferredCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "refresh_ahead_deferred_total",
+		Help:      "The total number of hot cached responses not refreshed because the budget was exhausted",
+	})
+
+	PeriodicInvalidatorReclaimedKeysCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "periodic_invalidator_reclaimed_keys_total",
+		Help:      "The total number of stale keys reclaimed by the periodic invalidator",
+	}, []string{"kind"})
 )
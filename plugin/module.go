@@ -33,20 +33,80 @@ var (
 			"metricsEnabled": sdkConfig.GetEnv("METRICS_ENABLED", "true"),
 			"metricsUnixDomainSocket": sdkConfig.GetEnv(
 				"METRICS_UNIX_DOMAIN_SOCKET", "/tmp/gatewayd-plugin-cache.sock"),
+			"metricsLabelLimit": sdkConfig.GetEnv(
+				"METRICS_LABEL_LIMIT", "100"),
+			"metricsTableLabels": sdkConfig.GetEnv(
+				"METRICS_TABLE_LABELS", "false"),
+			"metricsSampleInterval": sdkConfig.GetEnv(
+				"METRICS_SAMPLE_INTERVAL", "1m"),
+			"topQueriesCapacity": sdkConfig.GetEnv(
+				"TOP_QUERIES_CAPACITY", "1000"),
+			"topQueriesMetrics": sdkConfig.GetEnv(
+				"TOP_QUERIES_METRICS", "10"),
 			"metricsEndpoint": sdkConfig.GetEnv("METRICS_ENDPOINT", "/metrics"),
 			"apiGRPCAddress":  sdkConfig.GetEnv("API_GRPC_ADDRESS", "localhost:19090"),
-			"redisURL":        sdkConfig.GetEnv("REDIS_URL", "redis://localhost:6379/0"),
-			"expiry":          sdkConfig.GetEnv("EXPIRY", "1h"),
-			"defaultDBName":   sdkConfig.GetEnv("DEFAULT_DB_NAME", ""),
-			"scanCount":       sdkConfig.GetEnv("SCAN_COUNT", "1000"),
+			"adminEnabled":    sdkConfig.GetEnv("ADMIN_ENABLED", "false"),
+			"adminUnixDomainSocket": sdkConfig.GetEnv(
+				"ADMIN_UNIX_DOMAIN_SOCKET", "/tmp/gatewayd-plugin-cache-admin.sock"),
+			"redisURL": sdkConfig.GetEnv("REDIS_URL", "redis://localhost:6379/0"),
+			"expiry":   sdkConfig.GetEnv("EXPIRY", "1h"),
+			"emptyResultExpiry": sdkConfig.GetEnv(
+				"EMPTY_RESULT_EXPIRY", "0"),
+			"defaultDBName": sdkConfig.GetEnv("DEFAULT_DB_NAME", ""),
+			"scanCount":     sdkConfig.GetEnv("SCAN_COUNT", "1000"),
+			"keyPrefix":     sdkConfig.GetEnv("KEY_PREFIX", DefaultKeyPrefix),
+			"normalizedCacheKeys": sdkConfig.GetEnv(
+				"NORMALIZED_CACHE_KEYS", "false"),
+			"serverGroups": sdkConfig.GetEnv("SERVER_GROUPS", ""),
+			"shadowMode":   sdkConfig.GetEnv("SHADOW_MODE", "false"),
+			"verificationSampleRate": sdkConfig.GetEnv(
+				"VERIFICATION_SAMPLE_RATE", "0"),
+			"sessionBackupEnabled": sdkConfig.GetEnv(
+				"SESSION_BACKUP_ENABLED", "true"),
 			"periodicInvalidatorEnabled": sdkConfig.GetEnv(
 				"PERIODIC_INVALIDATOR_ENABLED", "true"),
 			"periodicInvalidatorStartDelay": sdkConfig.GetEnv(
 				"PERIODIC_INVALIDATOR_START_DELAY", "1m"),
 			"periodicInvalidatorInterval": sdkConfig.GetEnv(
 				"PERIODIC_INVALIDATOR_INTERVAL", "1m"),
+			"circuitBreakerEnabled": sdkConfig.GetEnv(
+				"CIRCUIT_BREAKER_ENABLED", "true"),
+			"circuitBreakerFailureThreshold": sdkConfig.GetEnv(
+				"CIRCUIT_BREAKER_FAILURE_THRESHOLD", "5"),
+			"circuitBreakerOpenDuration": sdkConfig.GetEnv(
+				"CIRCUIT_BREAKER_OPEN_DURATION", "10s"),
+			"circuitBreakerHalfOpenProbes": sdkConfig.GetEnv(
+				"CIRCUIT_BREAKER_HALF_OPEN_PROBES", "1"),
+			"postgresDSN": sdkConfig.GetEnv(
+				"POSTGRES_DSN", "postgres://postgres@localhost:5432/postgres?sslmode=disable"),
+			"warmupFile": sdkConfig.GetEnv("WARMUP_FILE", ""),
+			"warmupConcurrency": sdkConfig.GetEnv(
+				"WARMUP_CONCURRENCY", "4"),
+			"refreshAheadEnabled": sdkConfig.GetEnv(
+				"REFRESH_AHEAD_ENABLED", "false"),
+			"refreshAheadInterval": sdkConfig.GetEnv(
+				"REFRESH_AHEAD_INTERVAL", "10s"),
+			"refreshAheadMinHits": sdkConfig.GetEnv(
+				"REFRESH_AHEAD_MIN_HITS", "10"),
+			"refreshAheadFraction": sdkConfig.GetEnv(
+				"REFRESH_AHEAD_FRACTION", "0.1"),
+			"refreshAheadBudget": sdkConfig.GetEnv(
+				"REFRESH_AHEAD_BUDGET", "10"),
+			"tracingEnabled": sdkConfig.GetEnv(
+				"TRACING_ENABLED", "false"),
+			"tracingOTLPEndpoint": sdkConfig.GetEnv(
+				"TRACING_OTLP_ENDPOINT", "localhost:4317"),
+			"tracingOTLPInsecure": sdkConfig.GetEnv(
+				"TRACING_OTLP_INSECURE", "true"),
+			"tracingSampleRatio": sdkConfig.GetEnv(
+				"TRACING_SAMPLE_RATIO", "1"),
+			"shutdownTimeout": sdkConfig.GetEnv(
+				"SHUTDOWN_TIMEOUT", DefaultShutdownTimeout),
 			"exitOnStartupError": sdkConfig.GetEnv("EXIT_ON_STARTUP_ERROR", "false"),
 			"cacheBufferSize":    sdkConfig.GetEnv("CACHE_CHANNEL_BUFFER_SIZE", "100"),
+			"cacheWriters":       sdkConfig.GetEnv("CACHE_WRITERS", "4"),
+			"cacheOverflowPolicy": sdkConfig.GetEnv(
+				"CACHE_CHANNEL_OVERFLOW_POLICY", string(DefaultOverflowPolicy)),
 		},
 		"hooks": []interface{}{
 			int32(v1.HookName_HOOK_NAME_ON_CLOSED),
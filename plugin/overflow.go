@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+)
+
+// OverflowPolicy decides what happens to a server response when the cache
+// update channel is full.
+type OverflowPolicy string
+
+const (
+	// OverflowBlock waits until there is room in the channel. This might stall
+	// the traffic from the server if Redis is slow.
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropNewest drops the response that does not fit into the channel.
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+	// OverflowDropOldest drops the oldest response in the channel to make room.
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+
+	// DefaultOverflowPolicy is the overflow policy if none is configured, so that
+	// the cache never stalls the traffic from the server.
+	DefaultOverflowPolicy = OverflowDropNewest
+)
+
+// ParseOverflowPolicy parses the overflow policy of the cache update channel.
+func ParseOverflowPolicy(policy string) (OverflowPolicy, error) {
+	switch OverflowPolicy(policy) {
+	case OverflowBlock, OverflowDropNewest, OverflowDropOldest:
+		return OverflowPolicy(policy), nil
+	default:
+		return "", ErrInvalidOverflowPolicy
+	}
+}
+
+// enqueueServerResponse sends a server response to the cache update channel,
+// applying the overflow policy if the channel is full.
+func (p *Plugin) enqueueServerResponse(resp *v1.Struct) {
+	if p.updateCacheMutex != nil {
+		p.updateCacheMutex.RLock()
+		defer p.updateCacheMutex.RUnlock()
+	}
+	// The plugin is shutting down.
+	if p.updateCacheClosed {
+		return
+	}
+
+	defer func() {
+		UpdateCacheChannelDepthGauge.Set(float64(len(p.UpdateCacheChannel)))
+	}()
+
+	policy := p.OverflowPolicy
+	if policy == "" {
+		policy = DefaultOverflowPolicy
+	}
+
+	if policy == OverflowBlock {
+		select {
+		case p.UpdateCacheChannel <- resp:
+		case <-p.stopping:
+			CacheDroppedResponsesCounter.WithLabelValues(string(OverflowBlock)).Inc()
+			p.Logger.Trace("The plugin is shutting down, dropped the response waiting for the cache update channel")
+		}
+		return
+	}
+
+	select {
+	case p.UpdateCacheChannel <- resp:
+		return
+	default:
+	}
+
+	if policy == OverflowDropOldest {
+		select {
+		case <-p.UpdateCacheChannel:
+			CacheDroppedResponsesCounter.WithLabelValues(string(OverflowDropOldest)).Inc()
+			p.Logger.Trace("Cache update channel is full, dropped the oldest response")
+		default:
+		}
+
+		select {
+		case p.UpdateCacheChannel <- resp:
+			return
+		default:
+			// The channel was filled again by another client in the meantime.
+		}
+	}
+
+	CacheDroppedResponsesCounter.WithLabelValues(string(OverflowDropNewest)).Inc()
+	p.Logger.Trace("Cache update channel is full, dropped the newest response")
+}
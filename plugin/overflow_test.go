@@ -0,0 +1,89 @@
+package plugin
+
+import (
+	"testing"
+
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, policy := range []string{"block", "drop-newest", "drop-oldest"} {
+		parsed, err := ParseOverflowPolicy(policy)
+		assert.NoError(t, err)
+		assert.Equal(t, OverflowPolicy(policy), parsed)
+	}
+
+	_, err := ParseOverflowPolicy("drop")
+	assert.ErrorIs(t, err, ErrInvalidOverflowPolicy)
+}
+
+func newOverflowTestPlugin(policy OverflowPolicy) *Plugin {
+	return &Plugin{
+		Logger:             hclog.NewNullLogger(),
+		UpdateCacheChannel: make(chan *v1.Struct, 1),
+		OverflowPolicy:     policy,
+	}
+}
+
+func serverResponse(name string) *v1.Struct {
+	return &v1.Struct{Fields: map[string]*v1.Value{"name": v1.NewStringValue(name)}}
+}
+
+func TestEnqueueServerResponse_DropNewest(t *testing.T) {
+	p := newOverflowTestPlugin(OverflowDropNewest)
+	dropped := testutil.ToFloat64(CacheDroppedResponsesCounter.WithLabelValues("drop-newest"))
+
+	p.enqueueServerResponse(serverResponse("first"))
+	p.enqueueServerResponse(serverResponse("second"))
+
+	assert.Equal(t, dropped+1, testutil.ToFloat64(CacheDroppedResponsesCounter.WithLabelValues("drop-newest")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(UpdateCacheChannelDepthGauge))
+	assert.Equal(t, "first", (<-p.UpdateCacheChannel).GetFields()["name"].GetStringValue())
+}
+
+func TestEnqueueServerResponse_DefaultPolicy(t *testing.T) {
+	p := newOverflowTestPlugin("")
+	dropped := testutil.ToFloat64(CacheDroppedResponsesCounter.WithLabelValues(string(DefaultOverflowPolicy)))
+
+	p.enqueueServerResponse(serverResponse("first"))
+	p.enqueueServerResponse(serverResponse("second"))
+
+	assert.Equal(t, dropped+1,
+		testutil.ToFloat64(CacheDroppedResponsesCounter.WithLabelValues(string(DefaultOverflowPolicy))))
+	assert.Equal(t, "first", (<-p.UpdateCacheChannel).GetFields()["name"].GetStringValue())
+}
+
+func TestEnqueueServerResponse_DropOldest(t *testing.T) {
+	p := newOverflowTestPlugin(OverflowDropOldest)
+	dropped := testutil.ToFloat64(CacheDroppedResponsesCounter.WithLabelValues("drop-oldest"))
+
+	p.enqueueServerResponse(serverResponse("first"))
+	p.enqueueServerResponse(serverResponse("second"))
+
+	assert.Equal(t, dropped+1, testutil.ToFloat64(CacheDroppedResponsesCounter.WithLabelValues("drop-oldest")))
+	assert.Equal(t, "second", (<-p.UpdateCacheChannel).GetFields()["name"].GetStringValue())
+}
+
+func TestEnqueueServerResponse_Block(t *testing.T) {
+	p := newOverflowTestPlugin(OverflowBlock)
+
+	p.enqueueServerResponse(serverResponse("first"))
+	done := make(chan struct{})
+	go func() {
+		p.enqueueServerResponse(serverResponse("second"))
+		close(done)
+	}()
+
+	// The second response is only enqueued once the first one is consumed.
+	select {
+	case <-done:
+		t.Fatal("expected enqueue to block while the channel is full")
+	default:
+	}
+	assert.Equal(t, "first", (<-p.UpdateCacheChannel).GetFields()["name"].GetStringValue())
+	<-done
+	assert.Equal(t, "second", (<-p.UpdateCacheChannel).GetFields()["name"].GetStringValue())
+}
@@ -3,6 +3,8 @@ package plugin
 import (
 	"context"
 	"encoding/base64"
+	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -12,10 +14,15 @@ import (
 	sdkPlugin "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin"
 	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
 	apiV1 "github.com/gatewayd-io/gatewayd/api/v1"
+	"github.com/go-co-op/gocron"
 	"github.com/hashicorp/go-hclog"
 	goplugin "github.com/hashicorp/go-plugin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
 	goRedis "github.com/redis/go-redis/v9"
 	"github.com/spf13/cast"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/protobuf/proto"
 )
@@ -36,13 +43,98 @@ type Plugin struct {
 	ScanCount          int64
 	ExitOnStartupError bool
 
+	// EmptyResultExpiry is the expiry of the responses without rows, which are
+	// not cached if it is zero. It is usually shorter than Expiry.
+	EmptyResultExpiry time.Duration
+
+	// KeyPrefix is the prefix of all the keys owned by the plugin.
+	// The keys are further namespaced by KeySchemaVersion and their kind.
+	KeyPrefix string
+
+	// NormalizedCacheKeys replaces the raw request in cache keys with
+	// the fingerprint of the normalized query.
+	NormalizedCacheKeys bool
+	// ServerGroups maps backend addresses to logical cluster names, so that
+	// all backends of a cluster share cache entries and table indexes.
+	ServerGroups map[string]string
+
+	// Sessions holds the client sessions in memory. If SessionBackup is set,
+	// the database of each session is also stored in Redis, so that sessions
+	// survive restarts of the plugin. If Sessions is nil, only Redis is used.
+	Sessions      *SessionRegistry
+	SessionBackup bool
+
+	// MetricsLabelLimit is the number of distinct databases and tables in
+	// the labels of the metrics. Others are labeled OtherLabelValue.
+	MetricsLabelLimit int
+	// MetricsTableLabels labels cache hits and misses with the tables of
+	// the query, at the cost of parsing every query looked up in the cache.
+	MetricsTableLabels bool
+	metricLabelGuard   *LabelGuard
+
+	// ShadowMode looks up and caches responses without ever serving them, and
+	// compares the responses of the server with the cached ones, so that the
+	// hit rate and the stale responses can be evaluated before enabling caching.
+	// Stale responses are kept in StaleResponses, if set.
+	ShadowMode     bool
+	StaleResponses *StaleResponses
+
+	// VerificationSampleRate is the ratio of cache hits that are let through
+	// to the server, so that the response of the server is compared with the
+	// cached one. Stale cached responses are refreshed. It is disabled if zero.
+	VerificationSampleRate float64
+	verifications          *pendingVerifications
+
+	// PostgresConfig is the config of the plugin's own connections to the
+	// database server, which are used by the warm-up and the refresh-ahead.
+	PostgresConfig *pgconn.Config
+
+	// Refresh-ahead configuration. See RefreshAhead.
+	RefreshAheadMinHits  int64
+	RefreshAheadFraction float64
+	RefreshAheadBudget   int
+
+	// TopQueries keeps track of the most looked up queries. It is disabled if nil.
+	TopQueries *TopQueries
+
+	// Tracer creates the spans of cache lookups, stores and invalidations.
+	// No span is recorded if nil.
+	Tracer trace.Tracer
+
+	// CircuitBreaker makes the hooks bypass the cache while Redis is failing.
+	// It is disabled if nil.
+	CircuitBreaker *CircuitBreaker
+	// recoveryRequests wakes up RecoverMissedInvalidations. It is set by NewCachePlugin.
+	recoveryRequests chan struct{}
+
 	UpdateCacheChannel chan *v1.Struct
-	WaitGroup          *sync.WaitGroup
+	// CacheWriters is the number of goroutines writing responses to Redis.
+	CacheWriters int
+	// OverflowPolicy decides what happens to server responses when
+	// UpdateCacheChannel is full. Defaults to DefaultOverflowPolicy.
+	OverflowPolicy OverflowPolicy
+	WaitGroup      *sync.WaitGroup
+
+	// updateCacheMutex guards sending to UpdateCacheChannel against it being
+	// closed by Shutdown. It is set by NewCachePlugin.
+	updateCacheMutex  *sync.RWMutex
+	updateCacheClosed bool
+	// stopping is closed by stop when Shutdown begins, so that the responses waiting
+	// for room in UpdateCacheChannel are dropped instead of holding off its close,
+	// and the background goroutines return. They are set by NewCachePlugin.
+	stopping chan struct{}
+	stop     func()
+
+	// adminServer is the server of the admin API, which is stopped by Shutdown.
+	// It is guarded by adminMutex, which is set by NewCachePlugin.
+	adminServer *http.Server
+	adminMutex  *sync.Mutex
 
 	// Periodic invalidator configuration.
 	PeriodicInvalidatorEnabled    bool
 	PeriodicInvalidatorStartDelay time.Duration
 	PeriodicInvalidatorInterval   time.Duration
+	scheduler                     *gocron.Scheduler
 }
 
 type CachePlugin struct {
@@ -68,6 +160,17 @@ var pgDateTimeFunctions = map[string]struct{}{
 
 // NewCachePlugin returns a new instance of the CachePlugin.
 func NewCachePlugin(impl Plugin) *CachePlugin {
+	impl.updateCacheMutex = &sync.RWMutex{}
+	stopping := make(chan struct{})
+	impl.stopping = stopping
+	impl.stop = sync.OnceFunc(func() { close(stopping) })
+	impl.recoveryRequests = make(chan struct{}, 1)
+	impl.adminMutex = &sync.Mutex{}
+	impl.metricLabelGuard = NewLabelGuard()
+	impl.verifications = newPendingVerifications()
+	if impl.Sessions == nil {
+		impl.Sessions = NewSessionRegistry()
+	}
 	return &CachePlugin{
 		NetRPCUnsupportedPlugin: goplugin.NetRPCUnsupportedPlugin{},
 		Impl:                    impl,
@@ -98,29 +201,36 @@ func (p *Plugin) OnTrafficFromClient(
 	ctx context.Context, req *v1.Struct,
 ) (*v1.Struct, error) {
 	OnTrafficFromClientCounter.Inc()
+	defer prometheus.NewTimer(HookDurationHistogram.WithLabelValues(OnTrafficFromClientHook)).ObserveDuration()
 	req, err := postgres.HandleClientMessage(req, p.Logger)
 	if err != nil {
 		p.Logger.Info("Failed to handle client message", "error", err)
 	}
 
-	// This is used as a fallback if the database is not found in the startup message.
-	database := p.DefaultDBName
-	if database == "" {
-		client := cast.ToStringMapString(sdkPlugin.GetAttr(req, "client", nil))
-		database = p.getDBFromStartupMessage(ctx, req, database, client)
+	// Sessions are tracked even if the cache is bypassed, so that their
+	// requests can be cached once it is available again.
+	client := cast.ToStringMapString(sdkPlugin.GetAttr(req, "client", nil))
+	p.trackSession(ctx, req, client)
 
-		// Get the database from the cache if it's not found in the startup message or
-		// if the current request is not a startup message.
-		if database == "" {
-			database, err = p.RedisClient.Get(ctx, client["remote"]).Result()
-			if err != nil {
-				CacheErrorsCounter.Inc()
-				p.Logger.Debug("Failed to get cache", "error", err)
-			}
-			CacheGetsCounter.Inc()
-			p.Logger.Debug("Get the database in the cache for the current session",
-				"database", database, "client", client["remote"])
+	// Control statements are answered by the plugin, even if the cache is bypassed.
+	if query := cast.ToString(sdkPlugin.GetAttr(req, "query", "")); query != "" &&
+		p.answerControlStatement(ctx, req, query, client) {
+		return req, nil
+	}
+
+	if p.cacheBypassed() {
+		// Writes are recorded, so that their invalidations are applied once the
+		// cache is available again.
+		if query := cast.ToString(sdkPlugin.GetAttr(req, "query", "")); query != "" {
+			p.recordMissedInvalidation(query)
 		}
+		return req, nil
+	}
+
+	// The session's database is used as a fallback if no default database is set.
+	database := p.DefaultDBName
+	if database == "" {
+		database = p.getSessionDatabase(ctx, client)
 	}
 
 	// If the database is still not found, return the response as is without caching.
@@ -139,7 +249,7 @@ func (p *Plugin) OnTrafficFromClient(
 	query := cast.ToString(sdkPlugin.GetAttr(req, "query", ""))
 	request := cast.ToString(sdkPlugin.GetAttr(req, "request", ""))
 	server := cast.ToStringMapString(sdkPlugin.GetAttr(req, "server", ""))
-	cacheKey := strings.Join([]string{server["remote"], database, request}, ":")
+	cacheKey := p.getCacheKey(server["remote"], database, []byte(request))
 
 	if query == "" {
 		return req, nil
@@ -147,22 +257,71 @@ func (p *Plugin) OnTrafficFromClient(
 
 	p.Logger.Trace("Query", "query", query)
 
+	ctx, span := p.startSpan(ctx, "cache.lookup", semconv.DBNamespace(database))
+	defer span.End()
+	setSpanFingerprint(span, cacheKey)
+
 	// Clear the cache if the query is an insert, update or delete query.
 	p.invalidateDML(ctx, query)
 
+	// Queries with an invalidate hint clear the cache of their tags, and are
+	// always sent to the server.
+	if querySQL, err := p.decodeQuery(query); err == nil {
+		if tags := parseHints(querySQL).invalidateTags; len(tags) > 0 {
+			if _, err := p.invalidateTags(ctx, tags); err != nil {
+				p.Logger.Error("Failed to invalidate cached responses by tag", "error", err)
+			}
+			return req, nil
+		}
+	}
+
 	// Check if the query is cached.
-	response, err := p.RedisClient.Get(ctx, cacheKey).Bytes()
+	response, err := p.RedisClient.Get(ctx, p.responseKey(cacheKey)).Bytes()
 	if err != nil {
 		p.Logger.Debug("Failed to get cached response", "error", err)
 	}
 	CacheGetsCounter.Inc()
+	span.SetAttributes(CacheHitAttribute.Bool(response != nil))
+
+	// The tables are only parsed if they are labeled or traced.
+	var tables, metricTables []string
+	if p.MetricsTableLabels || span.IsRecording() {
+		tables = p.queryTables(query)
+		span.SetAttributes(CacheTablesAttribute.StringSlice(tables))
+	}
+	if p.MetricsTableLabels {
+		metricTables = tables
+	}
 
 	if response == nil {
 		// If the query is not cached, return the request as is.
 		CacheMissesCounter.Inc()
+		p.countPerTable(CacheTableMissesCounter, database, metricTables)
+		p.TopQueries.RecordLookup(cacheKey, request, false, 0)
+		return req, nil
+	}
+
+	// In shadow mode, the client is always served by the server.
+	if p.ShadowMode {
+		CacheShadowHitsCounter.Inc()
+		p.countPerTable(CacheTableShadowHitsCounter, database, metricTables)
+		p.TopQueries.RecordLookup(cacheKey, request, true, 0)
 		return req, nil
 	}
 
+	// A sample of the cache hits is sent to the server for verification.
+	if p.sampleVerification(cacheKey) {
+		CacheVerificationSamplesCounter.Inc()
+		p.TopQueries.RecordLookup(cacheKey, request, true, 0)
+		return req, nil
+	}
+
+	if span.IsRecording() {
+		if ttl, err := p.RedisClient.PTTL(ctx, p.responseKey(cacheKey)).Result(); err == nil {
+			span.SetAttributes(CacheTTLAttribute.String(ttl.String()))
+		}
+	}
+
 	// If the query is cached, return the cached response.
 	signals, err := v1.NewList([]any{
 		sdkAct.Terminate().ToMap(),
@@ -176,6 +335,8 @@ func (p *Plugin) OnTrafficFromClient(
 		p.Logger.Error("Failed to create signals", "error", err)
 	} else {
 		CacheHitsCounter.Inc()
+		p.countPerTable(CacheTableHitsCounter, database, metricTables)
+		p.TopQueries.RecordLookup(cacheKey, request, true, len(response))
 		// Return the cached response.
 		req.Fields[sdkAct.Signals] = v1.NewListValue(signals)
 		req.Fields["response"] = v1.NewBytesValue(response)
@@ -185,120 +346,181 @@ func (p *Plugin) OnTrafficFromClient(
 
 // IsCacheNeeded determines if caching is needed.
 func IsCacheNeeded(upperQuery string) bool {
+	// If the query contains a date/time function, caching is not needed.
+	return len(dateTimeFunctions(upperQuery)) == 0
+}
+
+// dateTimeFunctions returns the PostgreSQL date/time functions found in the query, sorted.
+func dateTimeFunctions(upperQuery string) []string {
+	var functions []string
 	// Iterate over each function name in the set of PostgreSQL date/time functions.
 	for function := range pgDateTimeFunctions {
 		if strings.Contains(upperQuery, function) {
-			// If the query contains a date/time function, caching is not needed.
-			return false
+			functions = append(functions, function)
 		}
 	}
-	return true
+	slices.Sort(functions)
+	return functions
 }
 
+// UpdateCache consumes the server responses from UpdateCacheChannel and hands
+// the cacheable ones to the cache writers, sharded by cache key.
 func (p *Plugin) UpdateCache(ctx context.Context) {
 	defer p.WaitGroup.Done()
+
+	writers, writersDone := p.startCacheWriters(ctx)
+	defer func() {
+		for _, writer := range writers {
+			close(writer)
+		}
+		writersDone.Wait()
+	}()
+
 	for {
-		serverResponse, ok := <-p.UpdateCacheChannel
+		var serverResponse *v1.Struct
+		var ok bool
+		select {
+		case <-ctx.Done():
+			p.Logger.Info("Context cancelled, dropping queued server responses",
+				"responses", len(p.UpdateCacheChannel))
+			return
+		case serverResponse, ok = <-p.UpdateCacheChannel:
+		}
 		if !ok {
 			p.Logger.Info("Channel closed, returning from function")
 			return
 		}
+		UpdateCacheChannelDepthGauge.Set(float64(len(p.UpdateCacheChannel)))
 
-		OnTrafficFromServerCounter.Inc()
-		resp, err := postgres.HandleServerMessage(serverResponse, p.Logger)
-		if err != nil {
-			p.Logger.Info("Failed to handle server message", "error", err)
+		timer := prometheus.NewTimer(HookDurationHistogram.WithLabelValues(UpdateCacheHook))
+		if write := p.prepareCacheWrite(ctx, serverResponse); write != nil {
+			writers[cacheWriterShard(write.cacheKey, len(writers))] <- write
 		}
+		timer.ObserveDuration()
+	}
+}
 
-		rowDescription := cast.ToString(sdkPlugin.GetAttr(resp, "rowDescription", ""))
-		dataRow := cast.ToStringSlice(sdkPlugin.GetAttr(resp, "dataRow", []interface{}{}))
-		errorResponse := cast.ToString(sdkPlugin.GetAttr(resp, "errorResponse", ""))
-		request, isOk := sdkPlugin.GetAttr(resp, "request", nil).([]byte)
-		if !isOk {
-			request = []byte{}
-		}
+// prepareCacheWrite decodes a server response and returns the write that caches it,
+// or nil if the response should not be cached.
+func (p *Plugin) prepareCacheWrite(ctx context.Context, serverResponse *v1.Struct) *cacheWrite {
+	OnTrafficFromServerCounter.Inc()
+	resp, err := postgres.HandleServerMessage(serverResponse, p.Logger)
+	if err != nil {
+		p.Logger.Info("Failed to handle server message", "error", err)
+	}
 
-		response, isOk := sdkPlugin.GetAttr(resp, "response", nil).([]byte)
-		if !isOk {
-			response = []byte{}
-		}
-		server := cast.ToStringMapString(sdkPlugin.GetAttr(resp, "server", ""))
-
-		// This is used as a fallback if the database is not found in the startup message.
-
-		database := p.DefaultDBName
-		if database == "" {
-			client := cast.ToStringMapString(sdkPlugin.GetAttr(resp, "client", ""))
-			if client != nil && client["remote"] != "" {
-				database, err = p.RedisClient.Get(ctx, client["remote"]).Result()
-				if err != nil {
-					CacheErrorsCounter.Inc()
-					p.Logger.Debug("Failed to get cached response", "error", err)
-				}
-				CacheGetsCounter.Inc()
-			}
-		}
+	rowDescription := cast.ToString(sdkPlugin.GetAttr(resp, "rowDescription", ""))
+	dataRow := cast.ToStringSlice(sdkPlugin.GetAttr(resp, "dataRow", []interface{}{}))
+	errorResponse := cast.ToString(sdkPlugin.GetAttr(resp, "errorResponse", ""))
+	request, isOk := sdkPlugin.GetAttr(resp, "request", nil).([]byte)
+	if !isOk {
+		request = []byte{}
+	}
 
-		// If the database is still not found, return the response as is without caching.
-		// This might also happen if the cache is cleared while the client is still connected.
-		// In this case, the client should reconnect and the error will go away.
-		if database == "" {
-			p.Logger.Debug("Database name not found or set in cache, startup message or plugin config. " +
-				"Skipping cache")
-			p.Logger.Debug("Consider setting the database name in the " +
-				"plugin config or disabling the plugin if you don't need it")
-			continue
-		}
+	response, isOk := sdkPlugin.GetAttr(resp, "response", nil).([]byte)
+	if !isOk {
+		response = []byte{}
+	}
+	server := cast.ToStringMapString(sdkPlugin.GetAttr(resp, "server", ""))
 
-		cacheKey := strings.Join([]string{server["remote"], database, string(request)}, ":")
-		if errorResponse != "" || rowDescription == "" || dataRow == nil || len(dataRow) == 0 {
-			continue
-		}
+	// This is used as a fallback if the database is not found in the startup message.
 
-		query, err := postgres.GetQueryFromRequest(request)
-		if err != nil {
-			p.Logger.Debug("Failed to get query from request", "error", err)
-			continue
-		}
+	database := p.DefaultDBName
+	if database == "" {
+		database = p.getSessionDatabase(
+			ctx, cast.ToStringMapString(sdkPlugin.GetAttr(resp, "client", "")))
+	}
 
-		if !IsCacheNeeded(strings.ToUpper(query)) {
-			continue
-		}
+	// If the database is still not found, return the response as is without caching.
+	// This might also happen if the cache is cleared while the client is still connected.
+	// In this case, the client should reconnect and the error will go away.
+	if database == "" {
+		p.Logger.Debug("Database name not found or set in cache, startup message or plugin config. " +
+			"Skipping cache")
+		p.Logger.Debug("Consider setting the database name in the " +
+			"plugin config or disabling the plugin if you don't need it")
+		return nil
+	}
 
-		// The request was successful and the response contains data. Cache the response.
-		if err := p.RedisClient.Set(ctx, cacheKey, response, p.Expiry).Err(); err != nil {
-			CacheErrorsCounter.Inc()
-			p.Logger.Debug("Failed to set cache", "error", err)
-		}
-		CacheSetsCounter.Inc()
+	cacheKey := p.getCacheKey(server["remote"], database, request)
+	verify := p.verifications != nil && p.verifications.take(cacheKey)
+	expiry, cacheable := p.responseExpiry(rowDescription != "", len(dataRow) > 0, errorResponse != "")
+	// A response that is not cached is still compared with the cached one, if it
+	// is verified or in shadow mode, as the cached response might be stale.
+	if !cacheable && !verify && !p.ShadowMode {
+		return nil
+	}
 
-		tables, err := postgres.GetTablesFromQuery(query)
-		if err != nil {
-			p.Logger.Debug("Failed to get tables from query", "error", err)
-			continue
-		}
+	query, err := postgres.GetQueryFromRequest(request)
+	if err != nil {
+		p.Logger.Debug("Failed to get query from request", "error", err)
+		return nil
+	}
 
-		// Cache the table(s) used in each cached request. This is used to invalidate
-		// the cache when a rows is inserted, updated or deleted into that table.
-		for _, table := range tables {
-			requestQueryCacheKey := strings.Join([]string{table, cacheKey}, ":")
-			if err := p.RedisClient.Set(
-				ctx, requestQueryCacheKey, "", p.Expiry).Err(); err != nil {
-				CacheErrorsCounter.Inc()
-				p.Logger.Debug("Failed to set cache", "error", err)
-			}
-			CacheSetsCounter.Inc()
-		}
+	if !IsCacheNeeded(strings.ToUpper(query)) {
+		return nil
+	}
+
+	// Writes are never cached, so there is nothing to compare their response with.
+	if !cacheable && invalidatesTables(strings.ToUpper(query)) {
+		return nil
+	}
+
+	hints := parseHints(query)
+	if len(hints.invalidateTags) > 0 {
+		return nil
+	}
+
+	// The request was successful and the response contains data. Cache the response,
+	// along with the table(s) used in the request. This is used to invalidate
+	// the cache when a rows is inserted, updated or deleted into that table.
+	tables, err := postgres.GetTablesFromQuery(query)
+	if err != nil {
+		p.Logger.Debug("Failed to get tables from query", "error", err)
+	}
+
+	return &cacheWrite{
+		cacheKey:    cacheKey,
+		response:    response,
+		query:       query,
+		tables:      tables,
+		tags:        hints.tags,
+		expiry:      expiry,
+		verify:      verify,
+		uncacheable: !cacheable,
+		spanContext: extractSpanContext(serverResponse),
+	}
+}
+
+// responseExpiry returns the expiry of a response and whether it is cached.
+// Responses with rows and no error are cached for Expiry. Empty result sets are
+// only cached for EmptyResultExpiry, if it is set.
+func (p *Plugin) responseExpiry(rowDescription, rows, errorResponse bool) (time.Duration, bool) {
+	switch {
+	case errorResponse || !rowDescription:
+		return 0, false
+	case rows:
+		return p.Expiry, true
+	default:
+		return p.EmptyResultExpiry, p.EmptyResultExpiry > 0
 	}
 }
 
 // OnTrafficFromServer is called when a response is received by GatewayD from the server.
 func (p *Plugin) OnTrafficFromServer(
-	_ context.Context, resp *v1.Struct,
+	ctx context.Context, resp *v1.Struct,
 ) (*v1.Struct, error) {
+	defer prometheus.NewTimer(HookDurationHistogram.WithLabelValues(OnTrafficFromServerHook)).ObserveDuration()
 	p.Logger.Debug("Traffic is coming from the server side")
+	p.trackTransactionStatus(resp)
+	if p.cacheBypassed() {
+		return resp, nil
+	}
+
 	if cloned, ok := proto.Clone(resp).(*v1.Struct); ok {
-		p.UpdateCacheChannel <- cloned
+		// The response is cached in the trace of the request, if any.
+		injectTraceContext(incomingTraceContext(ctx), cloned)
+		p.enqueueServerResponse(cloned)
 	}
 	return resp, nil
 }
@@ -306,14 +528,15 @@ func (p *Plugin) OnTrafficFromServer(
 func (p *Plugin) OnClosed(ctx context.Context, req *v1.Struct) (*v1.Struct, error) {
 	OnClosedCounter.Inc()
 	client := cast.ToStringMapString(sdkPlugin.GetAttr(req, "client", nil))
-	if client != nil {
-		if err := p.RedisClient.Del(ctx, client["remote"]).Err(); err != nil {
-			p.Logger.Debug("Failed to delete cache", "error", err)
-			CacheErrorsCounter.Inc()
+	if p.cacheBypassed() {
+		// The Redis backup of the session is deleted by the periodic invalidator.
+		if sessionID, err := clientSessionID(client); err == nil && p.Sessions != nil {
+			p.Sessions.Delete(sessionID)
 		}
-		p.Logger.Debug("Client closed", "client", client["remote"])
-		CacheDeletesCounter.Inc()
+		return req, nil
 	}
+
+	p.unregisterSession(ctx, client)
 	return req, nil
 }
 
@@ -321,119 +544,166 @@ func (p *Plugin) OnClosed(ctx context.Context, req *v1.Struct) (*v1.Struct, erro
 // This is done by getting the cached queries for each table and deleting them.
 func (p *Plugin) invalidateDML(ctx context.Context, query string) {
 	// Check if the query is a UPDATE, INSERT or DELETE.
-	queryDecoded, err := base64.StdEncoding.DecodeString(query)
+	querySQL, err := p.decodeQuery(query)
 	if err != nil {
-		p.Logger.Debug("Failed to decode query", "error", err)
 		return
 	}
 
-	queryMessage := cast.ToStringMapString(string(queryDecoded))
-	p.Logger.Trace("Query message", "query", queryMessage)
-
-	queryString := strings.ToUpper(queryMessage["String"])
-	// Ignore SELECT and WITH/SELECT queries.
-	// TODO: This is a naive approach, but query parsing has a cost.
-	if strings.HasPrefix(queryString, "SELECT") ||
-		(strings.HasPrefix(queryString, "WITH") &&
-			strings.Contains(queryString, "SELECT")) {
+	if !invalidatesTables(strings.ToUpper(querySQL)) {
 		return
 	}
 
-	tables, err := postgres.GetTablesFromQuery(queryMessage["String"])
+	tables, err := postgres.GetTablesFromQuery(querySQL)
 	if err != nil {
 		p.Logger.Debug("Failed to get tables from query", "error", err)
 		return
 	}
 
 	p.Logger.Trace("Tables", "tables", tables)
+	p.invalidateTables(ctx, tables)
+}
+
+// invalidatesTables checks if the query invalidates the cached responses of its tables.
+// SELECT and WITH/SELECT queries are ignored.
+// TODO: This is a naive approach, but query parsing has a cost.
+func invalidatesTables(upperQuery string) bool {
+	upperQuery = trimLeadingComments(upperQuery)
+	return !strings.HasPrefix(upperQuery, "SELECT") &&
+		!(strings.HasPrefix(upperQuery, "WITH") && strings.Contains(upperQuery, "SELECT"))
+}
+
+// decodeQuery returns the SQL of the query message decoded by the SDK.
+func (p *Plugin) decodeQuery(query string) (string, error) {
+	queryDecoded, err := base64.StdEncoding.DecodeString(query)
+	if err != nil {
+		p.Logger.Debug("Failed to decode query", "error", err)
+		return "", err
+	}
+
+	queryMessage := cast.ToStringMapString(string(queryDecoded))
+	p.Logger.Trace("Query message", "query", queryMessage)
+
+	return queryMessage["String"], nil
+}
+
+// queryTables returns the tables of the query message decoded by the SDK.
+func (p *Plugin) queryTables(query string) []string {
+	querySQL, err := p.decodeQuery(query)
+	if err != nil {
+		return nil
+	}
+
+	tables, err := postgres.GetTablesFromQuery(querySQL)
+	if err != nil {
+		p.Logger.Trace("Failed to get tables from query", "error", err)
+	}
+	return tables
+}
+
+// invalidateTables deletes the cached responses that depend on any of the tables,
+// along with their table index keys, and returns the number of deleted keys.
+func (p *Plugin) invalidateTables(ctx context.Context, tables []string) int {
+	ctx, span := p.startSpan(ctx, "cache.invalidate", CacheTablesAttribute.StringSlice(tables))
+	defer span.End()
+
+	deleted := 0
+	var failed []string
+	defer func() {
+		span.SetAttributes(CacheDeletedAttribute.Int(deleted))
+	}()
 	for _, table := range tables {
 		// Invalidate the cache for the table.
 		// TODO: This is not efficient. We should be able to invalidate the cache
 		// for a specific key instead of invalidating the entire table.
-		pipeline := p.RedisClient.Pipeline()
 		var cursor uint64
 		for {
-			scanResult := p.RedisClient.Scan(ctx, cursor, table+":*", p.ScanCount)
+			scanResult := p.RedisClient.Scan(ctx, cursor, p.tableIndexKey(table, "*"), p.ScanCount)
 			if scanResult.Err() != nil {
 				CacheErrorsCounter.Inc()
 				p.Logger.Debug("Failed to scan keys", "error", scanResult.Err())
+				failed = append(failed, table)
 				break
 			}
 			CacheScanCounter.Inc()
 
 			// Per each key, delete the cache entry and the table cache key itself.
+			// Both are deleted in a transaction, so that a cached response is never
+			// left behind without the index key that invalidates it.
 			var keys []string
 			keys, cursor = scanResult.Val()
 			CacheScanKeysCounter.Add(float64(len(keys)))
+			pipeline := p.RedisClient.TxPipeline()
+			databases := make([]string, 0, len(keys))
 			for _, tableKey := range keys {
 				// Invalidate the cache for the table.
-				cachedResponseKey := strings.TrimPrefix(tableKey, table+":")
-				pipeline.Del(ctx, cachedResponseKey)
+				cacheKey := p.cacheKeyFromTableIndexKey(table, tableKey)
+				pipeline.Del(ctx, p.responseKey(cacheKey))
 				// Invalidate the table cache key itself.
 				pipeline.Del(ctx, tableKey)
+
+				_, database, _, _ := parseCacheKey(cacheKey)
+				databases = append(databases, database)
+			}
+			count, err := p.execDeletePipeline(ctx, pipeline)
+			deleted += count
+			if err != nil {
+				failed = append(failed, table)
+				break
+			}
+			for _, database := range databases {
+				CacheTableInvalidationsCounter.WithLabelValues(p.metricLabels(database, table)...).Inc()
 			}
 
 			if cursor == 0 {
 				break
 			}
 		}
+	}
+
+	if len(failed) > 0 {
+		p.recordFailedInvalidation(failed, nil)
+	}
+	return deleted
+}
+
+// execDeletePipeline executes a pipeline of DEL commands and returns
+// the number of keys that were actually deleted, along with the error of
+// the pipeline, if any.
+func (p *Plugin) execDeletePipeline(ctx context.Context, pipeline goRedis.Pipeliner) (int, error) {
+	result, err := pipeline.Exec(ctx)
+	if err != nil {
+		p.Logger.Debug("Failed to execute pipeline", "error", err)
+	}
 
-		result, err := pipeline.Exec(ctx)
-		if err != nil {
-			p.Logger.Debug("Failed to execute pipeline", "error", err)
+	deleted := 0
+	for _, res := range result {
+		if res.Err() != nil {
+			CacheErrorsCounter.Inc()
+			continue
 		}
 
-		for _, res := range result {
-			if res.Err() != nil {
-				CacheErrorsCounter.Inc()
-			} else {
-				CacheDeletesCounter.Inc()
-			}
+		CacheDeletesCounter.Inc()
+		if cmd, ok := res.(*goRedis.IntCmd); ok {
+			deleted += int(cmd.Val())
 		}
 	}
+
+	return deleted, err
 }
 
-// getDBFromStartupMessage gets the database name from the startup message.
-func (p *Plugin) getDBFromStartupMessage(
-	ctx context.Context,
-	req *v1.Struct,
-	database string,
-	client map[string]string,
-) string {
-	// Try to get the database from the startup message, which is only sent once by the client.
-	// Store the database in the cache so that we can use it for subsequent requests.
-	startupMessageEncoded := cast.ToString(sdkPlugin.GetAttr(req, "startupMessage", ""))
-	if startupMessageEncoded == "" {
-		return database
-	}
-
-	startupMessageBytes, err := base64.StdEncoding.DecodeString(startupMessageEncoded)
-	if err != nil {
-		p.Logger.Debug("Failed to decode startup message", "error", err)
-		return database
-	}
-
-	startupMessage := cast.ToStringMap(string(startupMessageBytes))
-	p.Logger.Trace("Startup message", "startupMessage", startupMessage, "client", client)
-	if startupMessage != nil && client != nil {
-		startupMsgParams := cast.ToStringMapString(startupMessage["Parameters"])
-		if startupMsgParams != nil &&
-			startupMsgParams["database"] != "" &&
-			client["remote"] != "" {
-			if err := p.RedisClient.Set(
-				ctx, client["remote"],
-				startupMsgParams["database"],
-				time.Duration(0),
-			).Err(); err != nil {
-				CacheErrorsCounter.Inc()
-				p.Logger.Debug("Failed to set cache", "error", err)
-			}
-			CacheSetsCounter.Inc()
-			p.Logger.Debug("Set the database in the cache for the current session",
-				"database", database, "client", client["remote"])
-			return startupMsgParams["database"]
+// getCacheKey returns the key under which the response to the request is cached.
+// The key is scoped by the logical cluster of the server rather than its address.
+// If normalized cache keys are enabled, the request is replaced by the fingerprint
+// of its query. Requests that cannot be fingerprinted fall back to the raw request.
+func (p *Plugin) getCacheKey(server, database string, request []byte) string {
+	key := string(request)
+	if p.NormalizedCacheKeys {
+		if fingerprint, err := fingerprintRequest(request); err == nil {
+			key = fingerprint
+		} else {
+			p.Logger.Trace("Failed to fingerprint request, using the raw request", "error", err)
 		}
 	}
 
-	return database
+	return strings.Join([]string{clusterKey(p.getClusterName(server)), database, key}, KeySeparator)
 }
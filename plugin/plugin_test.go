@@ -79,6 +79,7 @@ func Test_Plugin(t *testing.T) {
 		RedisClient:        redisClient,
 		UpdateCacheChannel: updateCacheChannel,
 		WaitGroup:          &sync.WaitGroup{},
+		SessionBackup:      true,
 	})
 
 	p.Impl.WaitGroup.Add(1)
@@ -122,7 +123,7 @@ func Test_Plugin(t *testing.T) {
 	assert.Equal(t, result, req)
 
 	// Check that the database name was cached.
-	database := redisClient.Get(context.Background(), "localhost:45320").Val()
+	database := redisClient.Get(context.Background(), "gwc:v1:session:tcp:localhost:45320").Val()
 	assert.Equal(t, database, "postgres")
 
 	// Test the plugin's OnTrafficFromClient method.
@@ -181,7 +182,7 @@ func Test_Plugin(t *testing.T) {
 
 	// Check that the query and response was cached.
 	cachedResponse, err := redisClient.Get(
-		context.Background(), "localhost:5432:postgres:"+string(request)).Bytes()
+		context.Background(), "gwc:v1:resp:{localhost:5432}:postgres:"+string(request)).Bytes()
 	assert.Nil(t, err)
 	assert.Equal(t, cachedResponse, response)
 
@@ -214,6 +215,7 @@ func TestPluginDateFunctionInQuery(t *testing.T) {
 		RedisClient:        redisClient,
 		UpdateCacheChannel: cacheUpdateChannel,
 		WaitGroup:          &sync.WaitGroup{},
+		SessionBackup:      true,
 	})
 
 	plugin.Impl.WaitGroup.Add(1)
@@ -280,6 +282,7 @@ func newTestPlugin(t *testing.T) (*CachePlugin, *redis.Client) {
 		Expiry:             time.Hour,
 		ScanCount:          1000,
 		UpdateCacheChannel: make(chan *v1.Struct, 10),
+		SessionBackup:      true,
 		WaitGroup:          &sync.WaitGroup{},
 	})
 	return p, redisClient
@@ -317,8 +320,8 @@ func TestOnClosed(t *testing.T) {
 	ctx := context.Background()
 
 	// Simulate a stored client-to-database mapping.
-	redisClient.Set(ctx, "localhost:45320", "postgres", 0)
-	val := redisClient.Get(ctx, "localhost:45320").Val()
+	redisClient.Set(ctx, "gwc:v1:session:tcp:localhost:45320", "postgres", 0)
+	val := redisClient.Get(ctx, "gwc:v1:session:tcp:localhost:45320").Val()
 	assert.Equal(t, "postgres", val)
 
 	// Call OnClosed to clean up.
@@ -336,7 +339,7 @@ func TestOnClosed(t *testing.T) {
 	assert.NotNil(t, result)
 
 	// The client key should be deleted.
-	val = redisClient.Get(ctx, "localhost:45320").Val()
+	val = redisClient.Get(ctx, "gwc:v1:session:tcp:localhost:45320").Val()
 	assert.Equal(t, "", val)
 }
 
@@ -358,8 +361,8 @@ func TestInvalidateDML(t *testing.T) {
 	ctx := context.Background()
 
 	_, request := testQueryRequest()
-	cacheKey := "localhost:5432:postgres:" + string(request)
-	tableKey := "users:" + cacheKey
+	cacheKey := "gwc:v1:resp:{localhost:5432}:postgres:" + string(request)
+	tableKey := "gwc:v1:idx:users:{localhost:5432}:postgres:" + string(request)
 
 	// Pre-populate cache entries (simulating cached SELECT response + table index).
 	redisClient.Set(ctx, cacheKey, "cached-response-data", time.Hour)
@@ -428,7 +431,7 @@ func TestUpdateCacheContinuesOnError(t *testing.T) {
 		"VAAAABsAAWlkAAAAQAQAAQAAABcABP////8AAEQAAAALAAEAAAABMUMAAAANU0VMRUNUIDEAWgAAAAVJ")
 
 	// Set up the database mapping so UpdateCache can find it.
-	redisClient.Set(ctx, "localhost:45320", "postgres", 0)
+	redisClient.Set(ctx, "gwc:v1:session:tcp:localhost:45320", "postgres", 0)
 
 	goodArgs := map[string]interface{}{
 		"request":  request,
@@ -448,7 +451,163 @@ func TestUpdateCacheContinuesOnError(t *testing.T) {
 
 	// The valid message should have been cached (goroutine survived the error).
 	cachedResponse, err := redisClient.Get(
-		ctx, "localhost:5432:postgres:"+string(request)).Bytes()
+		ctx, "gwc:v1:resp:{localhost:5432}:postgres:"+string(request)).Bytes()
 	assert.Nil(t, err)
 	assert.Equal(t, response, cachedResponse)
 }
+
+func TestNormalizedCacheKeys(t *testing.T) {
+	p, redisClient := newTestPlugin(t)
+	p.Impl.NormalizedCacheKeys = true
+	ctx := context.Background()
+
+	p.Impl.WaitGroup.Add(1)
+	go p.Impl.UpdateCache(ctx)
+
+	redisClient.Set(ctx, "gwc:v1:session:tcp:localhost:45320", "postgres", 0)
+
+	_, request := testQueryRequest()
+	response, _ := base64.StdEncoding.DecodeString(
+		"VAAAABsAAWlkAAAAQAQAAQAAABcABP////8AAEQAAAALAAEAAAABMUMAAAANU0VMRUNUIDEAWgAAAAVJ")
+	args := map[string]interface{}{
+		"request":  request,
+		"response": response,
+		"client": map[string]interface{}{
+			"remote": "localhost:45320",
+		},
+		"server": map[string]interface{}{
+			"remote": "localhost:5432",
+		},
+	}
+	resp, _ := v1.NewStruct(args)
+	p.Impl.UpdateCacheChannel <- resp
+
+	close(p.Impl.UpdateCacheChannel)
+	p.Impl.WaitGroup.Wait()
+
+	// The response is stored under the fingerprint instead of the raw request.
+	fingerprint, err := fingerprintRequest(request)
+	assert.Nil(t, err)
+	cachedResponse, err := redisClient.Get(
+		ctx, "gwc:v1:resp:{localhost:5432}:postgres:"+fingerprint).Bytes()
+	assert.Nil(t, err)
+	assert.Equal(t, response, cachedResponse)
+	assert.Equal(t, int64(1), redisClient.Exists(
+		ctx, "gwc:v1:idx:users:{localhost:5432}:postgres:"+fingerprint).Val())
+
+	// A differently formatted query hits the same entry.
+	queryMsg := pgproto3.Query{String: "select *\n  from users -- all users"}
+	otherRequest, _ := queryMsg.Encode(nil)
+	args = map[string]interface{}{
+		"request": otherRequest,
+		"client": map[string]interface{}{
+			"remote": "localhost:45320",
+		},
+		"server": map[string]interface{}{
+			"remote": "localhost:5432",
+		},
+	}
+	req, _ := v1.NewStruct(args)
+	result, err := p.Impl.OnTrafficFromClient(ctx, req)
+	assert.Nil(t, err)
+	resultMap := result.AsMap()
+	assert.Equal(t, response, resultMap["response"])
+	assert.Contains(t, resultMap, sdkAct.Signals)
+}
+
+func TestServerGroupsShareCacheEntries(t *testing.T) {
+	p, redisClient := newTestPlugin(t)
+	p.Impl.ServerGroups = map[string]string{
+		"10.0.0.1:5432": "main",
+		"10.0.0.2:5432": "main",
+	}
+	ctx := context.Background()
+
+	p.Impl.WaitGroup.Add(1)
+	go p.Impl.UpdateCache(ctx)
+
+	redisClient.Set(ctx, "gwc:v1:session:tcp:localhost:45320", "postgres", 0)
+
+	// Cache the response via the first backend.
+	_, request := testQueryRequest()
+	response, _ := base64.StdEncoding.DecodeString(
+		"VAAAABsAAWlkAAAAQAQAAQAAABcABP////8AAEQAAAALAAEAAAABMUMAAAANU0VMRUNUIDEAWgAAAAVJ")
+	args := map[string]interface{}{
+		"request":  request,
+		"response": response,
+		"client": map[string]interface{}{
+			"remote": "localhost:45320",
+		},
+		"server": map[string]interface{}{
+			"remote": "10.0.0.1:5432",
+		},
+	}
+	resp, _ := v1.NewStruct(args)
+	p.Impl.UpdateCacheChannel <- resp
+
+	close(p.Impl.UpdateCacheChannel)
+	p.Impl.WaitGroup.Wait()
+
+	cachedResponse, err := redisClient.Get(ctx, "gwc:v1:resp:{main}:postgres:"+string(request)).Bytes()
+	assert.Nil(t, err)
+	assert.Equal(t, response, cachedResponse)
+
+	// The same query via the second backend hits the entry.
+	args = map[string]interface{}{
+		"request": request,
+		"client": map[string]interface{}{
+			"remote": "localhost:45320",
+		},
+		"server": map[string]interface{}{
+			"remote": "10.0.0.2:5432",
+		},
+	}
+	req, _ := v1.NewStruct(args)
+	result, err := p.Impl.OnTrafficFromClient(ctx, req)
+	assert.Nil(t, err)
+	assert.Equal(t, response, result.AsMap()["response"])
+}
+
+func TestEmptyResultCaching(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	p.DefaultDBName = "postgres"
+	ctx := context.Background()
+
+	_, request := testQueryRequest()
+	serverResponse, err := v1.NewStruct(map[string]interface{}{
+		"request":  request,
+		"response": encodeResponse(t),
+		"server": map[string]interface{}{
+			"remote": "localhost:5432",
+		},
+	})
+	assert.Nil(t, err)
+
+	// Empty result sets are not cached by default.
+	assert.Nil(t, p.prepareCacheWrite(ctx, serverResponse))
+
+	p.EmptyResultExpiry = time.Minute
+	write := p.prepareCacheWrite(ctx, serverResponse)
+	assert.NotNil(t, write)
+	p.writeCache(ctx, write)
+
+	cacheKey := "{localhost:5432}:postgres:" + string(request)
+	assert.Equal(t, string(encodeResponse(t)), redisClient.Get(ctx, p.responseKey(cacheKey)).Val())
+	assert.Equal(t, time.Minute, redisClient.TTL(ctx, p.responseKey(cacheKey)).Val())
+	assert.Equal(t, time.Minute, redisClient.TTL(ctx, p.tableIndexKey("users", cacheKey)).Val())
+
+	// Responses with rows are still cached for the expiry.
+	expiry, cacheable := p.responseExpiry(true, true, false)
+	assert.Equal(t, p.Expiry, expiry)
+	assert.True(t, cacheable)
+	_, cacheable = p.responseExpiry(true, false, true)
+	assert.False(t, cacheable)
+
+	// Inserts into the table invalidate the empty result set.
+	queryJSON, err := json.Marshal(map[string]string{"String": "INSERT INTO users VALUES (1)"})
+	assert.Nil(t, err)
+	p.invalidateDML(ctx, base64.StdEncoding.EncodeToString(queryJSON))
+	assert.Equal(t, int64(0), redisClient.Exists(ctx,
+		p.responseKey(cacheKey), p.tableIndexKey("users", cacheKey)).Val())
+}
@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd-plugin-sdk/databases/postgres"
+	"github.com/jackc/pgx/v5/pgconn"
+	goRedis "github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultRefreshAheadMinHits is the number of hits of an entry before it is refreshed ahead.
+	DefaultRefreshAheadMinHits = 10
+	// DefaultRefreshAheadFraction is the fraction of the expiry left when an entry is refreshed ahead.
+	DefaultRefreshAheadFraction = 0.1
+	// DefaultRefreshAheadBudget is the number of entries refreshed ahead per interval.
+	DefaultRefreshAheadBudget = 10
+)
+
+// RefreshAhead periodically re-runs the queries of the hot cached responses
+// that are about to expire, over its own connections to the database server in
+// PostgresConfig, and replaces the responses before they expire. A response is
+// hot if it was hit at least RefreshAheadMinHits times since it was last cached,
+// as tracked by TopQueries, and is about to expire if less than
+// RefreshAheadFraction of the expiry it was cached with is left. At most
+// RefreshAheadBudget responses are refreshed per interval, the most hit first,
+// so the interval should be well below the refreshed part of the expiry.
+// Each run is bounded by the interval, and is cancelled by Shutdown.
+func (p *Plugin) RefreshAhead(ctx context.Context, interval time.Duration) {
+	if p.TopQueries == nil || p.PostgresConfig == nil {
+		p.Logger.Error("Refresh-ahead requires top queries to be tracked and a Postgres connection")
+		return
+	}
+
+	if len(p.serverAddresses(p.postgresServer())) == 0 {
+		p.Logger.Warn("The database server can't be resolved nor is mapped to a cluster by SERVER_GROUPS, "+
+			"so no cached response is refreshed until it is", "server", p.postgresServer())
+	}
+
+	if err := p.schedule(interval, time.Now().Add(interval), func() {
+		ctx, cancel := p.stoppingContext(ctx)
+		defer cancel()
+		ctx, cancelRun := context.WithTimeout(ctx, interval)
+		defer cancelRun()
+		p.refreshAhead(ctx)
+	}); err != nil {
+		p.Logger.Error("Failed to start refresh-ahead",
+			"error", err, "interval", interval.String())
+		return
+	}
+
+	p.Logger.Debug("Started refresh-ahead", "interval", interval.String())
+}
+
+// refreshAhead refreshes the hot cached responses that are about to expire,
+// within the budget, and returns the number of responses it tried to refresh.
+func (p *Plugin) refreshAhead(ctx context.Context) int {
+	if p.cacheBypassed() {
+		p.Logger.Debug("Skipping refresh-ahead, because Redis is unavailable")
+		return 0
+	}
+
+	// Only the responses of the clusters of the server are refreshed. A host
+	// name is resolved every time, as the IPs of the server might change.
+	clusters := map[string]string{}
+	for _, server := range p.serverAddresses(p.postgresServer()) {
+		clusters[p.getClusterName(server)] = server
+	}
+
+	var candidates []QueryStats
+	var servers []string
+	for _, query := range p.TopQueries.Hot(p.RefreshAheadMinHits) {
+		queryCluster, _, _, ok := parseCacheKey(query.cacheKey)
+		if server, found := clusters[queryCluster]; ok && found {
+			candidates = append(candidates, query)
+			servers = append(servers, server)
+		}
+	}
+	if len(candidates) == 0 {
+		return 0
+	}
+
+	pipeline := p.RedisClient.Pipeline()
+	ttls := make([]*goRedis.DurationCmd, 0, len(candidates))
+	for _, query := range candidates {
+		ttls = append(ttls, pipeline.PTTL(ctx, p.responseKey(query.cacheKey)))
+	}
+	if _, err := pipeline.Exec(ctx); err != nil {
+		p.Logger.Debug("Failed to get the TTL of hot cached responses", "error", err)
+	}
+
+	connections := map[string]*pgconn.PgConn{}
+	defer func() {
+		for _, conn := range connections {
+			conn.Close(context.Background())
+		}
+	}()
+
+	refreshed := 0
+	for i, query := range candidates {
+		// Expired responses are cached again by the next miss.
+		ttl, err := ttls[i].Result()
+		if err != nil || ttl <= 0 || ttl > p.refreshAheadThreshold(query) {
+			continue
+		}
+
+		if refreshed >= p.RefreshAheadBudget {
+			RefreshAheadDeferredCounter.Inc()
+			continue
+		}
+
+		_, database, _, _ := parseCacheKey(query.cacheKey)
+		sql, err := postgres.GetQueryFromRequest([]byte(query.queryRequest()))
+		if err != nil {
+			// Only simple queries can be refreshed.
+			RefreshAheadQueriesCounter.WithLabelValues(WarmupSkipped).Inc()
+			continue
+		}
+
+		outcome := p.cacheQuery(ctx, p.PostgresConfig, []string{servers[i]}, connections, WarmupEntry{
+			Database: database,
+			SQL:      sql,
+		})
+		RefreshAheadQueriesCounter.WithLabelValues(outcome).Inc()
+		refreshed++
+		p.Logger.Debug("Refreshed hot cached response ahead of expiry",
+			"database", database, "ttl", ttl.String(), "hits", query.RecentHits, "result", outcome)
+	}
+
+	return refreshed
+}
+
+// refreshAheadThreshold returns the TTL below which the cached response of the
+// query is about to expire, which is RefreshAheadFraction of the expiry the
+// response was cached with, e.g. EmptyResultExpiry, or of Expiry if it is unknown.
+func (p *Plugin) refreshAheadThreshold(query QueryStats) time.Duration {
+	expiry := query.expiry
+	if expiry <= 0 {
+		expiry = p.Expiry
+	}
+	return time.Duration(float64(expiry) * p.RefreshAheadFraction)
+}
@@ -0,0 +1,161 @@
+package plugin
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshAhead(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	p.NormalizedCacheKeys = true
+	p.TopQueries = NewTopQueries(DefaultTopQueriesCapacity)
+	p.RefreshAheadMinHits = 2
+	p.RefreshAheadFraction = 0.1
+	p.RefreshAheadBudget = 1
+	ctx := context.Background()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	go serveTestPostgres(t, listener)
+
+	p.PostgresConfig, err = pgconn.ParseConfig(
+		"postgres://postgres@" + listener.Addr().String() + "/postgres?sslmode=disable")
+	assert.Nil(t, err)
+	server := listener.Addr().String()
+
+	// Each response is cached with the given expiry, is hit the given number of
+	// times and expires after the given TTL.
+	cache := func(query string, expiry time.Duration, hits int, ttl time.Duration) string {
+		cacheKey := populateCache(t, p, redisClient, server, "postgres", query, "users")
+		redisClient.Expire(ctx, p.responseKey(cacheKey), ttl)
+		request, err := (&pgproto3.Query{String: query}).Encode(nil)
+		assert.Nil(t, err)
+		p.TopQueries.RecordLookup(cacheKey, string(request), false, 0)
+		p.TopQueries.RecordStore(cacheKey, expiry)
+		for range hits {
+			p.TopQueries.RecordLookup(cacheKey, string(request), true, 1)
+		}
+		return cacheKey
+	}
+	users := cache("SELECT * FROM users", time.Hour, 3, time.Minute)
+	posts := cache("SELECT * FROM posts", time.Hour, 2, time.Minute)
+	cold := cache("SELECT * FROM tags", time.Hour, 1, time.Minute)
+	longLived := cache("SELECT * FROM users WHERE id = 1", time.Hour, 5, 30*time.Minute)
+	// The response was cached with a shorter expiry, e.g. as an empty result,
+	// so it is not about to expire yet.
+	shortLived := cache("SELECT * FROM users WHERE id = 2", time.Minute, 5, 50*time.Second)
+
+	deferred := testutil.ToFloat64(RefreshAheadDeferredCounter)
+	assert.Equal(t, 1, p.refreshAhead(ctx))
+	assert.InDelta(t, deferred+1, testutil.ToFloat64(RefreshAheadDeferredCounter), 0)
+
+	// The most hit response is refreshed with the response of the server.
+	response, err := redisClient.Get(ctx, p.responseKey(users)).Bytes()
+	assert.Nil(t, err)
+	result, err := DecodeResponse(response)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{"alice"}}, result.Rows)
+	assert.Greater(t, redisClient.TTL(ctx, p.responseKey(users)).Val(), 30*time.Minute)
+
+	// The others are left as is.
+	for _, cacheKey := range []string{posts, cold, longLived, shortLived} {
+		assert.LessOrEqual(t, redisClient.TTL(ctx, p.responseKey(cacheKey)).Val(), 30*time.Minute)
+	}
+
+	// The refreshed response is not hot anymore until it is hit again, so the
+	// deferred one is tried instead.
+	redisClient.Expire(ctx, p.responseKey(users), time.Minute)
+	deferred = testutil.ToFloat64(RefreshAheadDeferredCounter)
+	assert.Equal(t, 1, p.refreshAhead(ctx))
+	assert.InDelta(t, deferred, testutil.ToFloat64(RefreshAheadDeferredCounter), 0)
+	assert.LessOrEqual(t, redisClient.TTL(ctx, p.responseKey(users)).Val(), time.Minute)
+}
+
+func TestRefreshAheadWithHostName(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	p.TopQueries = NewTopQueries(DefaultTopQueriesCapacity)
+	p.RefreshAheadMinHits = 1
+	p.RefreshAheadFraction = 0.1
+	p.RefreshAheadBudget = 1
+	ctx := context.Background()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	go serveTestPostgres(t, listener)
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	assert.Nil(t, err)
+
+	stubLookupHost(t, map[string][]string{"db.test": {"127.0.0.1"}})
+	p.PostgresConfig, err = pgconn.ParseConfig(
+		"postgres://postgres@db.test:" + port + "/postgres?sslmode=disable")
+	assert.Nil(t, err)
+	p.PostgresConfig.LookupFunc = func(_ context.Context, host string) ([]string, error) {
+		return lookupHost(host)
+	}
+
+	// The response was cached for the IP of the server reported by GatewayD.
+	cacheKey := populateCache(
+		t, p, redisClient, listener.Addr().String(), "postgres", "SELECT * FROM users", "users")
+	redisClient.Expire(ctx, p.responseKey(cacheKey), time.Minute)
+	request, err := (&pgproto3.Query{String: "SELECT * FROM users"}).Encode(nil)
+	assert.Nil(t, err)
+	p.TopQueries.RecordLookup(cacheKey, string(request), true, 1)
+
+	assert.Equal(t, 1, p.refreshAhead(ctx))
+	assert.Greater(t, redisClient.TTL(ctx, p.responseKey(cacheKey)).Val(), 30*time.Minute)
+
+	// Nothing is refreshed if the host name can't be resolved.
+	stubLookupHost(t, nil)
+	redisClient.Expire(ctx, p.responseKey(cacheKey), time.Minute)
+	assert.Equal(t, 0, p.refreshAhead(ctx))
+}
+
+func TestShutdownCancelsRefreshAhead(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	p.TopQueries = NewTopQueries(DefaultTopQueriesCapacity)
+	p.RefreshAheadMinHits = 1
+	p.RefreshAheadFraction = 0.1
+	p.RefreshAheadBudget = 1
+	ctx := context.Background()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	go serveTestPostgres(t, listener)
+
+	p.PostgresConfig, err = pgconn.ParseConfig(
+		"postgres://postgres@" + listener.Addr().String() + "/postgres?sslmode=disable")
+	assert.Nil(t, err)
+
+	// The query of the hot response hangs.
+	query := "SELECT pg_sleep(3600)"
+	cacheKey := populateCache(t, p, redisClient, listener.Addr().String(), "postgres", query)
+	redisClient.Expire(ctx, p.responseKey(cacheKey), time.Minute)
+	request, err := (&pgproto3.Query{String: query}).Encode(nil)
+	assert.Nil(t, err)
+	p.TopQueries.RecordLookup(cacheKey, string(request), true, 1)
+
+	p.RefreshAhead(ctx, time.Hour)
+	p.scheduler.RunAll()
+	assert.Eventually(t, func() bool {
+		return p.scheduler.Jobs()[0].IsRunning()
+	}, time.Second, 10*time.Millisecond)
+
+	// The running refresh is cancelled, so it doesn't hold off the shutdown.
+	drainCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	assert.Nil(t, p.Shutdown(drainCtx))
+	assert.False(t, p.scheduler.IsRunning())
+}
@@ -0,0 +1,316 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	sdkPlugin "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin"
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+	"github.com/jackc/pgx/v5/pgproto3"
+	goRedis "github.com/redis/go-redis/v9"
+	"github.com/spf13/cast"
+)
+
+const (
+	// ReadyForQueryMessageType is the type of the message that ends every response.
+	ReadyForQueryMessageType = 'Z'
+	// ReadyForQueryLength is the length of a ReadyForQuery message.
+	ReadyForQueryLength = 6
+	// CloseStatementType is the type of a Close message that closes a prepared statement.
+	CloseStatementType = 'S'
+)
+
+// Session is the state of a client session.
+type Session struct {
+	Database        string `json:"database"`
+	User            string `json:"user,omitempty"`
+	ApplicationName string `json:"applicationName,omitempty"`
+	// TransactionStatus is the status reported by the last ReadyForQuery message:
+	// 'I' if idle, 'T' in a transaction block and 'E' in a failed transaction block.
+	TransactionStatus byte `json:"transactionStatus,omitempty"`
+	// PreparedStatements maps the names of the prepared statements to their queries.
+	PreparedStatements map[string]string `json:"preparedStatements,omitempty"`
+}
+
+// SessionRegistry holds the client sessions in memory, keyed by session ID.
+type SessionRegistry struct {
+	mutex    sync.RWMutex
+	sessions map[string]*Session
+}
+
+// NewSessionRegistry returns an empty session registry.
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{sessions: map[string]*Session{}}
+}
+
+// Get returns a copy of the session.
+func (r *SessionRegistry) Get(sessionID string) (Session, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	session, ok := r.sessions[sessionID]
+	if !ok {
+		return Session{}, false
+	}
+
+	copied := *session
+	copied.PreparedStatements = maps.Clone(session.PreparedStatements)
+	return copied, true
+}
+
+// Register adds or replaces a session.
+func (r *SessionRegistry) Register(sessionID string, session Session) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sessions[sessionID] = &session
+	SessionsGauge.Set(float64(len(r.sessions)))
+}
+
+// Update changes a registered session, and reports whether it is registered.
+func (r *SessionRegistry) Update(sessionID string, update func(session *Session)) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	session, ok := r.sessions[sessionID]
+	if ok {
+		update(session)
+	}
+	return ok
+}
+
+// Delete removes a session.
+func (r *SessionRegistry) Delete(sessionID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.sessions, sessionID)
+	SessionsGauge.Set(float64(len(r.sessions)))
+}
+
+// IDs returns the IDs of all the registered sessions.
+func (r *SessionRegistry) IDs() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	sessionIDs := make([]string, 0, len(r.sessions))
+	for sessionID := range r.sessions {
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	return sessionIDs
+}
+
+// clientSessionID returns the session ID of a client, as passed to the hooks.
+func clientSessionID(client map[string]string) (string, error) {
+	if client == nil {
+		return "", ErrUnnamedClientAddress
+	}
+
+	sessionID, err := NewSessionID(client["remote"])
+	if err != nil {
+		return "", err
+	}
+
+	return sessionID.String(), nil
+}
+
+// getSessionDatabase returns the database of the client session. Sessions are
+// looked up in memory, and only if they are not registered, e.g. because the
+// plugin was restarted, in the Redis backup.
+func (p *Plugin) getSessionDatabase(ctx context.Context, client map[string]string) string {
+	sessionID, err := clientSessionID(client)
+	if err != nil {
+		p.Logger.Debug("Failed to get the session of the client", "client", client, "error", err)
+		return ""
+	}
+
+	if p.Sessions != nil {
+		if session, ok := p.Sessions.Get(sessionID); ok {
+			return session.Database
+		}
+
+		if !p.SessionBackup {
+			return ""
+		}
+	}
+
+	database, err := p.RedisClient.Get(ctx, p.sessionKey(sessionID)).Result()
+	if err != nil && !errors.Is(err, goRedis.Nil) {
+		CacheErrorsCounter.Inc()
+		p.Logger.Debug("Failed to get the database of the session", "error", err)
+	}
+	CacheGetsCounter.Inc()
+	p.Logger.Debug("Get the database in the cache for the current session",
+		"database", database, "client", client["remote"])
+
+	// Restore the session, so that Redis is only queried once.
+	if database != "" && p.Sessions != nil {
+		p.Sessions.Register(sessionID, Session{Database: database})
+	}
+
+	return database
+}
+
+// trackSession registers the session of the client on its startup message,
+// and keeps track of the prepared statements of the session.
+func (p *Plugin) trackSession(ctx context.Context, req *v1.Struct, client map[string]string) {
+	if client == nil {
+		return
+	}
+
+	if startupMessage := cast.ToString(sdkPlugin.GetAttr(req, "startupMessage", "")); startupMessage != "" {
+		p.registerSession(ctx, startupMessage, client)
+		return
+	}
+
+	// Simple queries don't change prepared statements.
+	request, ok := sdkPlugin.GetAttr(req, "request", nil).([]byte)
+	if !ok || p.Sessions == nil || len(request) == 0 || request[0] == QueryMessageType {
+		return
+	}
+
+	sessionID, err := clientSessionID(client)
+	if err != nil {
+		return
+	}
+
+	// A request might contain multiple messages of the extended query protocol.
+	backend := pgproto3.NewBackend(bytes.NewReader(request), nil)
+	for {
+		message, err := backend.Receive()
+		if err != nil {
+			return
+		}
+
+		switch message := message.(type) {
+		case *pgproto3.Parse:
+			p.Sessions.Update(sessionID, func(session *Session) {
+				if session.PreparedStatements == nil {
+					session.PreparedStatements = map[string]string{}
+				}
+				session.PreparedStatements[message.Name] = message.Query
+			})
+		case *pgproto3.Close:
+			if message.ObjectType == CloseStatementType {
+				p.Sessions.Update(sessionID, func(session *Session) {
+					delete(session.PreparedStatements, message.Name)
+				})
+			}
+		}
+	}
+}
+
+// registerSession registers the session of the client from its startup message
+// and backs up its database in Redis, if enabled.
+func (p *Plugin) registerSession(ctx context.Context, startupMessageEncoded string, client map[string]string) {
+	startupMessageBytes, err := base64.StdEncoding.DecodeString(startupMessageEncoded)
+	if err != nil {
+		p.Logger.Debug("Failed to decode startup message", "error", err)
+		return
+	}
+
+	startupMessage := cast.ToStringMap(string(startupMessageBytes))
+	p.Logger.Trace("Startup message", "startupMessage", startupMessage, "client", client)
+	params := cast.ToStringMapString(startupMessage["Parameters"])
+	if params["database"] == "" {
+		return
+	}
+
+	sessionID, err := clientSessionID(client)
+	if err != nil {
+		p.Logger.Debug("Failed to get the session of the client", "client", client, "error", err)
+		return
+	}
+
+	if p.Sessions != nil {
+		p.Sessions.Register(sessionID, Session{
+			Database:          params["database"],
+			User:              params["user"],
+			ApplicationName:   params["application_name"],
+			TransactionStatus: 'I',
+		})
+	}
+
+	if p.Sessions == nil || p.SessionBackup {
+		if err := p.RedisClient.Set(
+			ctx, p.sessionKey(sessionID), params["database"], time.Duration(0),
+		).Err(); err != nil {
+			CacheErrorsCounter.Inc()
+			p.Logger.Debug("Failed to set cache", "error", err)
+		}
+		CacheSetsCounter.Inc()
+	}
+
+	p.Logger.Debug("Set the database in the cache for the current session",
+		"database", params["database"], "client", client["remote"])
+}
+
+// trackTransactionStatus updates the transaction status of the session from the
+// ReadyForQuery message at the end of the response.
+func (p *Plugin) trackTransactionStatus(resp *v1.Struct) {
+	if p.Sessions == nil {
+		return
+	}
+
+	response, ok := sdkPlugin.GetAttr(resp, "response", nil).([]byte)
+	if !ok || len(response) < ReadyForQueryLength ||
+		response[len(response)-ReadyForQueryLength] != ReadyForQueryMessageType {
+		return
+	}
+
+	client := cast.ToStringMapString(sdkPlugin.GetAttr(resp, "client", nil))
+	sessionID, err := clientSessionID(client)
+	if err != nil {
+		return
+	}
+
+	p.Sessions.Update(sessionID, func(session *Session) {
+		session.TransactionStatus = response[len(response)-1]
+	})
+}
+
+// unregisterSession removes the session of the client from memory and from the Redis backup.
+func (p *Plugin) unregisterSession(ctx context.Context, client map[string]string) {
+	sessionID, err := clientSessionID(client)
+	if err != nil {
+		return
+	}
+
+	if p.Sessions != nil {
+		p.Sessions.Delete(sessionID)
+	}
+
+	if p.Sessions == nil || p.SessionBackup {
+		if err := p.RedisClient.Del(ctx, p.sessionKey(sessionID)).Err(); err != nil {
+			p.Logger.Debug("Failed to delete cache", "error", err)
+			CacheErrorsCounter.Inc()
+		}
+		CacheDeletesCounter.Inc()
+	}
+	p.Logger.Debug("Client closed", "client", client["remote"])
+}
+
+// deleteStaleRegisteredSessions removes the sessions of clients that are not
+// connected to GatewayD anymore from memory.
+func (p *Plugin) deleteStaleRegisteredSessions(proxies map[string]map[string]Proxy) int {
+	if p.Sessions == nil {
+		return 0
+	}
+
+	deleted := 0
+	for _, sessionID := range p.Sessions.IDs() {
+		_, address, _ := strings.Cut(sessionID, KeySeparator)
+		if isBusy(proxies, address) {
+			continue
+		}
+
+		p.Sessions.Delete(sessionID)
+		deleted++
+	}
+
+	return deleted
+}
@@ -0,0 +1,121 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+)
+
+func testClientRequest(t *testing.T, request []byte) *v1.Struct {
+	t.Helper()
+	req, err := v1.NewStruct(map[string]interface{}{
+		"request": request,
+		"client": map[string]interface{}{
+			"local":  "localhost:15432",
+			"remote": "[::1]:45320",
+		},
+		"server": map[string]interface{}{
+			"remote": "localhost:5432",
+		},
+	})
+	assert.Nil(t, err)
+	return req
+}
+
+func TestSessionRegistry(t *testing.T) {
+	p, redisClient := newTestPlugin(t)
+	p.Impl.SessionBackup = false
+	ctx := context.Background()
+
+	startupMsg := pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters: map[string]string{
+			"user":             "alice",
+			"database":         "shop",
+			"application_name": "psql",
+		},
+	}
+	startupRequest, _ := startupMsg.Encode(nil)
+	_, err := p.Impl.OnTrafficFromClient(ctx, testClientRequest(t, startupRequest))
+	assert.Nil(t, err)
+
+	session, ok := p.Impl.Sessions.Get("tcp:[::1]:45320")
+	assert.True(t, ok)
+	assert.Equal(t, Session{
+		Database:          "shop",
+		User:              "alice",
+		ApplicationName:   "psql",
+		TransactionStatus: 'I',
+	}, session)
+	// Without the backup, the session is only held in memory.
+	assert.Equal(t, int64(0), redisClient.Exists(ctx, p.Impl.sessionKey("tcp:[::1]:45320")).Val())
+	assert.Equal(t, "shop", p.Impl.getSessionDatabase(ctx, map[string]string{"remote": "[::1]:45320"}))
+
+	// Prepared statements are tracked from the extended query protocol.
+	request, _ := (&pgproto3.Parse{Name: "users", Query: "SELECT * FROM users"}).Encode(nil)
+	request, _ = (&pgproto3.Parse{Name: "posts", Query: "SELECT * FROM posts"}).Encode(request)
+	request, _ = (&pgproto3.Sync{}).Encode(request)
+	_, err = p.Impl.OnTrafficFromClient(ctx, testClientRequest(t, request))
+	assert.Nil(t, err)
+
+	request, _ = (&pgproto3.Close{ObjectType: 'S', Name: "posts"}).Encode(nil)
+	_, err = p.Impl.OnTrafficFromClient(ctx, testClientRequest(t, request))
+	assert.Nil(t, err)
+
+	session, _ = p.Impl.Sessions.Get("tcp:[::1]:45320")
+	assert.Equal(t, map[string]string{"users": "SELECT * FROM users"}, session.PreparedStatements)
+
+	// The transaction status is tracked from the responses.
+	response, _ := (&pgproto3.CommandComplete{CommandTag: []byte("BEGIN")}).Encode(nil)
+	response, _ = (&pgproto3.ReadyForQuery{TxStatus: 'T'}).Encode(response)
+	resp, _ := v1.NewStruct(map[string]interface{}{
+		"response": response,
+		"client": map[string]interface{}{
+			"remote": "[::1]:45320",
+		},
+	})
+	_, err = p.Impl.OnTrafficFromServer(ctx, resp)
+	assert.Nil(t, err)
+
+	session, _ = p.Impl.Sessions.Get("tcp:[::1]:45320")
+	assert.Equal(t, byte('T'), session.TransactionStatus)
+
+	// The session is removed when the client disconnects.
+	_, err = p.Impl.OnClosed(ctx, testClientRequest(t, nil))
+	assert.Nil(t, err)
+	_, ok = p.Impl.Sessions.Get("tcp:[::1]:45320")
+	assert.False(t, ok)
+}
+
+func TestSessionRegistryRestoresFromBackup(t *testing.T) {
+	p, redisClient := newTestPlugin(t)
+	ctx := context.Background()
+
+	// The session was registered before the plugin was restarted.
+	redisClient.Set(ctx, p.Impl.sessionKey("tcp:[::1]:45320"), "shop", 0)
+
+	client := map[string]string{"remote": "[::1]:45320"}
+	assert.Equal(t, "shop", p.Impl.getSessionDatabase(ctx, client))
+
+	// Subsequent lookups are served from memory.
+	redisClient.Del(ctx, p.Impl.sessionKey("tcp:[::1]:45320"))
+	assert.Equal(t, "shop", p.Impl.getSessionDatabase(ctx, client))
+}
+
+func TestDeleteStaleRegisteredSessions(t *testing.T) {
+	p, _ := newTestPlugin(t)
+	p.Impl.Sessions.Register("tcp:[::1]:45320", Session{Database: "shop"})
+	p.Impl.Sessions.Register("tcp:[::1]:45321", Session{Database: "shop"})
+
+	proxies := map[string]map[string]Proxy{
+		"default": {"default": {Busy: []string{"[::1]:45320"}}},
+	}
+	assert.Equal(t, 1, p.Impl.deleteStaleRegisteredSessions(proxies))
+	assert.Equal(t, []string{"tcp:[::1]:45320"}, p.Impl.Sessions.IDs())
+
+	// Without the API, no session is deleted.
+	assert.Equal(t, 0, p.Impl.deleteStaleRegisteredSessions(nil))
+}
@@ -0,0 +1,67 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// TextFormatCode is the format code of values sent in text format.
+const TextFormatCode = 0
+
+// ResultSet is a decoded query response.
+type ResultSet struct {
+	Columns         []string
+	Rows            [][]string
+	CommandComplete string
+	Error           string
+}
+
+// DecodeResponse decodes a cached response into a result set. Values sent in
+// binary format are hex encoded and NULL values are returned as "NULL".
+func DecodeResponse(response []byte) (*ResultSet, error) {
+	frontend := pgproto3.NewFrontend(bytes.NewReader(response), nil)
+	result := &ResultSet{}
+	var formats []int16
+
+	for {
+		message, err := frontend.Receive()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return result, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch message := message.(type) {
+		case *pgproto3.RowDescription:
+			result.Columns = make([]string, len(message.Fields))
+			formats = make([]int16, len(message.Fields))
+			for i, field := range message.Fields {
+				result.Columns[i] = string(field.Name)
+				formats[i] = field.Format
+			}
+		case *pgproto3.DataRow:
+			row := make([]string, len(message.Values))
+			for i, value := range message.Values {
+				switch {
+				case value == nil:
+					row[i] = "NULL"
+				case i < len(formats) && formats[i] != TextFormatCode:
+					row[i] = "\\x" + hex.EncodeToString(value)
+				default:
+					row[i] = string(value)
+				}
+			}
+			result.Rows = append(result.Rows, row)
+		case *pgproto3.CommandComplete:
+			result.CommandComplete = string(message.CommandTag)
+		case *pgproto3.ErrorResponse:
+			result.Error = message.Message
+		case *pgproto3.ReadyForQuery:
+			return result, nil
+		}
+	}
+}
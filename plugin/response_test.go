@@ -0,0 +1,35 @@
+package plugin
+
+import (
+	"encoding/base64"
+	"testing"
+
+	pgproto3 "github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeResponse(t *testing.T) {
+	response, err := base64.StdEncoding.DecodeString(
+		"VAAAABsAAWlkAAAAQAQAAQAAABcABP////8AAEQAAAALAAEAAAABMUMAAAANU0VMRUNUIDEAWgAAAAVJ")
+	assert.Nil(t, err)
+
+	result, err := DecodeResponse(response)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"id"}, result.Columns)
+	assert.Equal(t, [][]string{{"1"}}, result.Rows)
+	assert.Equal(t, "SELECT 1", result.CommandComplete)
+	assert.Empty(t, result.Error)
+}
+
+func TestDecodeResponse_NullAndBinary(t *testing.T) {
+	response, _ := (&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{
+		{Name: []byte("name"), Format: TextFormatCode},
+		{Name: []byte("data"), Format: 1},
+	}}).Encode(nil)
+	response, _ = (&pgproto3.DataRow{Values: [][]byte{nil, {0xca, 0xfe}}}).Encode(response)
+	response, _ = (&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}).Encode(response)
+
+	result, err := DecodeResponse(response)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{"NULL", "\\xcafe"}}, result.Rows)
+}
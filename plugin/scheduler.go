@@ -2,79 +2,33 @@ package plugin
 
 import (
 	"context"
+	"maps"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/go-co-op/gocron"
 )
 
+// InvalidationReport is the number of keys reclaimed by a run of the periodic invalidator.
+type InvalidationReport struct {
+	StaleSessions     int
+	OrphanedIndexKeys int
+	StaleResponses    int
+}
+
 // PeriodicInvalidator is a function that runs periodically and deletes all the
-// cached client keys that are not valid anymore. This has two purposes:
-// 1. If a client is not connected to the GatewayD anymore, it will be deleted.
-// 2. Invalidate stale keys for responses. (This is not implemented yet.)
+// cached keys that are not valid anymore. This has three purposes:
+// 1. If a client is not connected to the GatewayD anymore, its session key will be deleted.
+// 2. Cached responses of servers that are not in any proxy pool anymore will be deleted.
+// 3. Table and tag index keys whose cached response has expired or was deleted will be deleted.
 // https://github.com/gatewayd-io/gatewayd-plugin-cache/issues/4
-func (p *Plugin) PeriodicInvalidator() {
-	scheduler := gocron.NewScheduler(time.UTC)
+// The scheduler is stopped by Shutdown.
+func (p *Plugin) PeriodicInvalidator(ctx context.Context) {
 	startDelay := time.Now().Add(p.PeriodicInvalidatorStartDelay)
 
-	if _, err := scheduler.Every(p.PeriodicInvalidatorInterval).SingletonMode().StartAt(startDelay).Do(func() {
-		proxies := p.getProxies()
-		p.Logger.Trace("Got proxies from GatewayD", "proxies", proxies)
-
-		// Get all the client keys and delete the ones that are not valid.
-		var cursor uint64
-		for {
-			scanResult := p.RedisClient.Scan(context.Background(), cursor, "*:*", p.ScanCount)
-			if scanResult.Err() != nil {
-				p.Logger.Error("Failed to scan keys", "error", scanResult.Err())
-				break
-			}
-			CacheScanCounter.Inc()
-
-			var addresses []string
-			addresses, cursor = scanResult.Val()
-			CacheScanKeysCounter.Add(float64(len(addresses)))
-			for _, address := range addresses {
-				valid := false
-
-				// Validate the address if the address is an IP address.
-				if ok, err := validateAddressPort(address); ok && err == nil {
-					valid = true
-				} else {
-					p.Logger.Trace(
-						"Skipping connection because it is invalid", "address", address, "error", err)
-				}
-
-				if !valid {
-					// Validate the address if the address is a hostname.
-					if ok, err := validateHostPort(address); ok && err == nil {
-						valid = true
-					} else {
-						p.Logger.Trace(
-							"Skipping connection because it is invalid", "address", address, "error", err)
-						continue
-					}
-				}
-
-				// If the address is not valid, skip it.
-				if !valid {
-					continue
-				}
-
-				// If the connection is busy (a client is connected), it is not safe to delete the key.
-				if isBusy(proxies, address) {
-					p.Logger.Trace("Skipping connection because it is busy", "address", address)
-					continue
-				}
-
-				p.RedisClient.Del(context.Background(), address)
-				p.Logger.Trace("Deleted stale address", "address", address)
-				CacheDeletesCounter.Inc()
-			}
-
-			if cursor == 0 {
-				break
-			}
-		}
+	if err := p.schedule(p.PeriodicInvalidatorInterval, startDelay, func() {
+		p.invalidatePeriodically(ctx)
 	}); err != nil {
 		p.Logger.Error("Failed to start periodic invalidator",
 			"error", err,
@@ -83,8 +37,176 @@ func (p *Plugin) PeriodicInvalidator() {
 		return
 	}
 
-	scheduler.StartAsync()
 	p.Logger.Debug("Started periodic invalidator",
 		"interval", p.PeriodicInvalidatorInterval.String(),
 		"delay", p.PeriodicInvalidatorStartDelay.String())
 }
+
+// schedule runs the job every interval, starting at startAt. The jobs share the
+// scheduler of the plugin, which is started on first use and stopped by Shutdown.
+func (p *Plugin) schedule(interval time.Duration, startAt time.Time, job func()) error {
+	if p.scheduler == nil {
+		p.scheduler = gocron.NewScheduler(time.UTC)
+		p.scheduler.StartAsync()
+	}
+
+	_, err := p.scheduler.Every(interval).SingletonMode().StartAt(startAt).Do(job)
+	return err
+}
+
+// invalidatePeriodically runs the periodic invalidator once and reports
+// how many keys were reclaimed.
+func (p *Plugin) invalidatePeriodically(ctx context.Context) InvalidationReport {
+	var report InvalidationReport
+	if p.cacheBypassed() {
+		p.Logger.Debug("Skipping periodic invalidation, because Redis is unavailable")
+		return report
+	}
+
+	report.StaleSessions = p.deleteStaleSessions(ctx)
+	report.StaleResponses = p.deleteStaleServerResponses(ctx)
+
+	orphaned, err := p.PurgeOrphanedIndexKeys(ctx)
+	if err != nil {
+		p.Logger.Error("Failed to purge orphaned index keys", "error", err)
+	}
+	report.OrphanedIndexKeys = orphaned
+
+	PeriodicInvalidatorReclaimedKeysCounter.WithLabelValues("session").Add(
+		float64(report.StaleSessions))
+	PeriodicInvalidatorReclaimedKeysCounter.WithLabelValues("response").Add(
+		float64(report.StaleResponses))
+	PeriodicInvalidatorReclaimedKeysCounter.WithLabelValues("index").Add(
+		float64(report.OrphanedIndexKeys))
+
+	p.Logger.Info("Periodic invalidator reclaimed stale keys",
+		"sessions", report.StaleSessions,
+		"responses", report.StaleResponses,
+		"orphanedIndexKeys", report.OrphanedIndexKeys)
+
+	return report
+}
+
+// deleteStaleSessions deletes the session keys and the registered sessions of
+// clients that are not connected to GatewayD anymore. Only the session namespace
+// is scanned, so response and table index keys are never mistaken for clients.
+func (p *Plugin) deleteStaleSessions(ctx context.Context) int {
+	proxies := p.getProxies()
+	p.Logger.Trace("Got proxies from GatewayD", "proxies", proxies)
+
+	deleted := 0
+	// Get all the session keys and delete the ones that are not valid.
+	var cursor uint64
+	for {
+		scanResult := p.RedisClient.Scan(ctx, cursor, p.sessionKey("*"), p.ScanCount)
+		if scanResult.Err() != nil {
+			p.Logger.Error("Failed to scan keys", "error", scanResult.Err())
+			break
+		}
+		CacheScanCounter.Inc()
+
+		var sessionKeys []string
+		sessionKeys, cursor = scanResult.Val()
+		CacheScanKeysCounter.Add(float64(len(sessionKeys)))
+		for _, sessionKey := range sessionKeys {
+			sessionID, err := ParseSessionID(strings.TrimPrefix(sessionKey, p.sessionKey("")))
+			if err != nil {
+				p.Logger.Trace(
+					"Skipping session because it is invalid", "session", sessionKey, "error", err)
+				continue
+			}
+			address := sessionID.Address
+
+			// If the address is not valid, skip it.
+			if ok, err := sessionID.Validate(); !ok || err != nil {
+				p.Logger.Trace(
+					"Skipping connection because it is invalid", "address", address, "error", err)
+				continue
+			}
+
+			// If the connection is busy (a client is connected), it is not safe to delete the key.
+			if isBusy(proxies, address) {
+				p.Logger.Trace("Skipping connection because it is busy", "address", address)
+				continue
+			}
+
+			if err := p.RedisClient.Del(ctx, sessionKey).Err(); err != nil {
+				CacheErrorsCounter.Inc()
+				p.Logger.Debug("Failed to delete stale address", "address", address, "error", err)
+				continue
+			}
+			p.Logger.Trace("Deleted stale address", "address", address)
+			CacheDeletesCounter.Inc()
+			deleted++
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	return deleted + p.deleteStaleRegisteredSessions(proxies)
+}
+
+// deleteStaleServerResponses deletes the cached responses, along with their table
+// index keys, of servers that are not in any proxy pool of GatewayD anymore.
+func (p *Plugin) deleteStaleServerResponses(ctx context.Context) int {
+	addresses := p.getServerAddresses()
+	if addresses == nil {
+		// NOTE: If the API is not running, we assume that all the servers are still
+		// in use, so that we don't accidentally wipe the cache.
+		return 0
+	}
+
+	// The servers are configured by address, usually a host name, but cache keys
+	// have the IP GatewayD connected to.
+	clusters := map[string]struct{}{}
+	for address := range addresses {
+		for _, resolved := range resolveServerAddress(address) {
+			clusters[p.getClusterName(resolved)] = struct{}{}
+		}
+	}
+
+	// If no cached cluster is a known server, the servers are more likely resolved
+	// differently by GatewayD than all removed, so nothing is deleted.
+	cached, err := p.cachedClusters(ctx)
+	if err != nil {
+		p.Logger.Error("Failed to get the clusters of cached responses", "error", err)
+		return 0
+	}
+	known := len(cached) == 0
+	for cluster := range cached {
+		if _, ok := clusters[cluster]; ok {
+			known = true
+			break
+		}
+	}
+	if !known {
+		p.Logger.Warn("No cached cluster matches a server of GatewayD, keeping their cached responses",
+			"servers", slices.Sorted(maps.Keys(clusters)), "cached", slices.Sorted(maps.Keys(cached)))
+		return 0
+	}
+
+	deleted, err := p.invalidateMatching(ctx, func(cluster, _, _ string) bool {
+		_, ok := clusters[cluster]
+		return !ok
+	})
+	if err != nil {
+		p.Logger.Error("Failed to delete cached responses of stale servers", "error", err)
+	}
+
+	return deleted
+}
+
+// cachedClusters returns the clusters of the cached responses.
+func (p *Plugin) cachedClusters(ctx context.Context) (map[string]struct{}, error) {
+	clusters := map[string]struct{}{}
+	err := p.scanKeys(ctx, p.responseKey("*"), func(keys []string) {
+		for _, key := range keys {
+			if cluster, _, _, ok := parseCacheKey(strings.TrimPrefix(key, p.responseKey(""))); ok {
+				clusters[cluster] = struct{}{}
+			}
+		}
+	})
+	return clusters, err
+}
@@ -0,0 +1,140 @@
+package plugin
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	apiV1 "github.com/gatewayd-io/gatewayd/api/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// fakeAPIClient returns fixed proxies and global config, as GatewayD would.
+type fakeAPIClient struct {
+	apiV1.GatewayDAdminAPIServiceClient
+
+	proxies      map[string]any
+	globalConfig map[string]any
+}
+
+func (f *fakeAPIClient) GetProxies(
+	context.Context, *emptypb.Empty, ...grpc.CallOption,
+) (*structpb.Struct, error) {
+	return structpb.NewStruct(f.proxies)
+}
+
+func (f *fakeAPIClient) GetGlobalConfig(
+	context.Context, *apiV1.Group, ...grpc.CallOption,
+) (*structpb.Struct, error) {
+	return structpb.NewStruct(f.globalConfig)
+}
+
+func newFakeAPIClient(busyClients []any, serverAddresses ...string) *fakeAPIClient {
+	clients := map[string]any{}
+	for _, address := range serverAddresses {
+		clients[address] = map[string]any{"address": address}
+	}
+
+	return &fakeAPIClient{
+		proxies: map[string]any{
+			"default": map[string]any{
+				"default": map[string]any{
+					"available": []any{},
+					"busy":      busyClients,
+					"total":     len(busyClients),
+				},
+			},
+		},
+		globalConfig: map[string]any{
+			"clients": map[string]any{"default": clients},
+		},
+	}
+}
+
+// stubLookupHost resolves the host names to the IPs for the duration of the test.
+func stubLookupHost(t *testing.T, hosts map[string][]string) {
+	t.Helper()
+	original := lookupHost
+	lookupHost = func(host string) ([]string, error) {
+		if ips, ok := hosts[host]; ok {
+			return ips, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	t.Cleanup(func() { lookupHost = original })
+}
+
+func TestInvalidatePeriodically(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	p.APIClient = newFakeAPIClient(
+		[]any{"localhost:45320", "[::1]:45320", "/tmp/client.sock"}, "db.internal:5432")
+	stubLookupHost(t, map[string][]string{"db.internal": {"10.0.0.1", "fd00::1"}})
+	ctx := context.Background()
+
+	// Sessions of connected and disconnected TCP, IPv6 and Unix domain socket clients.
+	connected := []string{"tcp:localhost:45320", "tcp:[::1]:45320", "unix:/tmp/client.sock"}
+	for _, sessionID := range connected {
+		redisClient.Set(ctx, p.sessionKey(sessionID), "postgres", 0)
+	}
+	redisClient.Set(ctx, p.sessionKey("tcp:localhost:45321"), "postgres", 0)
+	redisClient.Set(ctx, p.sessionKey("tcp:[::1]:45321"), "postgres", 0)
+	redisClient.Set(ctx, p.sessionKey("unix:/tmp/closed.sock"), "postgres", 0)
+	// Responses of a live server, cached by the IPs GatewayD connected to, and of
+	// a server that was removed from GatewayD.
+	live := populateCache(t, p, redisClient, "10.0.0.1:5432", "postgres", "SELECT * FROM users", "users")
+	liveIPv6 := populateCache(t, p, redisClient, "[fd00::1]:5432", "postgres", "SELECT * FROM users", "users")
+	stale := populateCache(t, p, redisClient, "localhost:5433", "postgres", "SELECT * FROM users", "users")
+	// A table index key whose response has expired.
+	orphan := p.tableIndexKey("posts", "{10.0.0.1:5432}:postgres:gone")
+	redisClient.Set(ctx, orphan, "", time.Hour)
+
+	report := p.invalidatePeriodically(ctx)
+	assert.Equal(t, InvalidationReport{
+		StaleSessions:     3,
+		StaleResponses:    2,
+		OrphanedIndexKeys: 1,
+	}, report)
+
+	for _, sessionID := range connected {
+		assert.Equal(t, int64(1), redisClient.Exists(ctx, p.sessionKey(sessionID)).Val(), sessionID)
+	}
+	assert.Equal(t, int64(4), redisClient.Exists(ctx,
+		p.responseKey(live), p.tableIndexKey("users", live),
+		p.responseKey(liveIPv6), p.tableIndexKey("users", liveIPv6)).Val())
+	assert.Equal(t, int64(0), redisClient.Exists(
+		ctx, p.responseKey(stale), p.tableIndexKey("users", stale), orphan).Val())
+}
+
+func TestInvalidatePeriodicallyWithoutAPI(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	ctx := context.Background()
+
+	redisClient.Set(ctx, p.sessionKey("tcp:localhost:45321"), "postgres", 0)
+	cacheKey := populateCache(t, p, redisClient, "localhost:5433", "postgres", "SELECT * FROM users", "users")
+
+	// Without the API, nothing that might still be in use is deleted.
+	report := p.invalidatePeriodically(ctx)
+	assert.Equal(t, InvalidationReport{}, report)
+	assert.Equal(t, int64(3), redisClient.Exists(
+		ctx, p.sessionKey("tcp:localhost:45321"), p.responseKey(cacheKey), p.tableIndexKey("users", cacheKey)).Val())
+}
+
+func TestDeleteStaleServerResponsesWithoutKnownCluster(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	p.APIClient = newFakeAPIClient(nil, "db.internal:5432")
+	stubLookupHost(t, map[string][]string{})
+	ctx := context.Background()
+
+	// The server can't be resolved, so none of the cached clusters is known.
+	cacheKey := populateCache(t, p, redisClient, "10.0.0.1:5432", "postgres", "SELECT * FROM users", "users")
+	assert.Equal(t, 0, p.deleteStaleServerResponses(ctx))
+	assert.Equal(t, int64(2), redisClient.Exists(
+		ctx, p.responseKey(cacheKey), p.tableIndexKey("users", cacheKey)).Val())
+}
@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"net"
+	"strings"
+)
+
+const (
+	TCPNetwork  = "tcp"
+	UnixNetwork = "unix"
+)
+
+// SessionID identifies a client session by the network and the address of the
+// client, e.g. "tcp:[::1]:45320" or "unix:/tmp/client.sock".
+type SessionID struct {
+	Network string
+	Address string
+}
+
+// NewSessionID returns the session ID of a client from its remote address, as
+// reported by GatewayD. Clients connected via an unnamed Unix domain socket have
+// no distinguishable address, so they cannot be tracked: GatewayD reports them
+// with an empty or "@" address and passes no other identifier of the connection
+// to the hooks, nor lists them distinguishably among the busy connections.
+func NewSessionID(remote string) (SessionID, error) {
+	remote = strings.TrimSpace(remote)
+	switch {
+	case remote == "" || remote == "@":
+		return SessionID{}, ErrUnnamedClientAddress
+	case strings.HasPrefix(remote, "/") || strings.HasPrefix(remote, "@"):
+		return SessionID{Network: UnixNetwork, Address: remote}, nil
+	}
+
+	host, port, err := net.SplitHostPort(remote)
+	if err != nil {
+		return SessionID{}, ErrInvalidAddressPortPair
+	}
+
+	// Normalize IP addresses, so that e.g. IPv6 addresses are always written the same way.
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		host = ip.String()
+	}
+
+	return SessionID{Network: TCPNetwork, Address: net.JoinHostPort(host, port)}, nil
+}
+
+// ParseSessionID parses a session ID from its string representation.
+func ParseSessionID(sessionID string) (SessionID, error) {
+	network, address, found := strings.Cut(sessionID, KeySeparator)
+	if !found || address == "" || (network != TCPNetwork && network != UnixNetwork) {
+		return SessionID{}, ErrInvalidSessionID
+	}
+
+	return SessionID{Network: network, Address: address}, nil
+}
+
+func (s SessionID) String() string {
+	return s.Network + KeySeparator + s.Address
+}
+
+// Validate checks if the address of the session is valid.
+func (s SessionID) Validate() (bool, error) {
+	if s.Network == UnixNetwork {
+		return s.Address != "", nil
+	}
+
+	// Validate the address if the address is an IP address.
+	if ok, err := validateAddressPort(s.Address); ok && err == nil {
+		return true, nil
+	}
+
+	// Validate the address if the address is a hostname.
+	return validateHostPort(s.Address)
+}
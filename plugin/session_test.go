@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSessionID(t *testing.T) {
+	tests := map[string]SessionID{
+		"localhost:45320":               {Network: TCPNetwork, Address: "localhost:45320"},
+		"127.0.0.1:45320":               {Network: TCPNetwork, Address: "127.0.0.1:45320"},
+		"[::1]:45320":                   {Network: TCPNetwork, Address: "[::1]:45320"},
+		"[0:0:0:0:0:0:0:1]:45320":       {Network: TCPNetwork, Address: "[::1]:45320"},
+		"[fe80::1%eth0]:45320":          {Network: TCPNetwork, Address: "[fe80::1%eth0]:45320"},
+		"/var/run/postgresql/client.sk": {Network: UnixNetwork, Address: "/var/run/postgresql/client.sk"},
+		"@abstract":                     {Network: UnixNetwork, Address: "@abstract"},
+	}
+
+	for remote, expected := range tests {
+		sessionID, err := NewSessionID(remote)
+		assert.NoError(t, err, remote)
+		assert.Equal(t, expected, sessionID, remote)
+	}
+}
+
+func TestNewSessionID_Fails(t *testing.T) {
+	// Unnamed Unix domain socket clients.
+	_, err := NewSessionID("")
+	assert.ErrorIs(t, err, ErrUnnamedClientAddress)
+	_, err = NewSessionID("@")
+	assert.ErrorIs(t, err, ErrUnnamedClientAddress)
+
+	_, err = NewSessionID("::1")
+	assert.ErrorIs(t, err, ErrInvalidAddressPortPair)
+}
+
+func TestParseSessionID(t *testing.T) {
+	for _, remote := range []string{"localhost:45320", "[::1]:45320", "/tmp/client.sock"} {
+		sessionID, err := NewSessionID(remote)
+		assert.NoError(t, err)
+
+		parsed, err := ParseSessionID(sessionID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, sessionID, parsed)
+
+		valid, err := parsed.Validate()
+		assert.True(t, valid, remote)
+		assert.NoError(t, err)
+	}
+
+	_, err := ParseSessionID("localhost:45320")
+	assert.ErrorIs(t, err, ErrInvalidSessionID)
+	_, err = ParseSessionID("tcp:")
+	assert.ErrorIs(t, err, ErrInvalidSessionID)
+}
@@ -0,0 +1,128 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	goRedis "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultStaleResponsesCapacity is the number of stale responses kept in memory.
+const DefaultStaleResponsesCapacity = 100
+
+// StaleResponse is a cached response that differs from the response of the
+// server, which the cache would have served instead.
+type StaleResponse struct {
+	Cluster     string    `json:"cluster"`
+	Database    string    `json:"database"`
+	Query       string    `json:"query,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Tables      []string  `json:"tables"`
+	CachedBytes int       `json:"cachedBytes"`
+	FreshBytes  int       `json:"freshBytes"`
+	Time        time.Time `json:"time"`
+}
+
+// StaleResponses keeps the most recent stale responses seen in shadow mode.
+type StaleResponses struct {
+	Capacity int
+
+	mutex     sync.Mutex
+	responses []StaleResponse
+	next      int
+	total     int64
+}
+
+// NewStaleResponses returns a log of the most recent stale responses.
+func NewStaleResponses(capacity int) *StaleResponses {
+	if capacity <= 0 {
+		capacity = DefaultStaleResponsesCapacity
+	}
+
+	return &StaleResponses{
+		Capacity:  capacity,
+		responses: make([]StaleResponse, 0, capacity),
+	}
+}
+
+// Add records a stale response, replacing the oldest one if the log is full.
+func (s *StaleResponses) Add(response StaleResponse) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.total++
+	if len(s.responses) < s.Capacity {
+		s.responses = append(s.responses, response)
+		return
+	}
+	s.responses[s.next] = response
+	s.next = (s.next + 1) % s.Capacity
+}
+
+// Recent returns the total number of stale responses and the most recent
+// ones, the newest first.
+func (s *StaleResponses) Recent() (int64, []StaleResponse) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	recent := make([]StaleResponse, 0, len(s.responses))
+	for i := range s.responses {
+		index := (s.next - 1 - i + 2*len(s.responses)) % len(s.responses)
+		recent = append(recent, s.responses[index])
+	}
+	return s.total, recent
+}
+
+// compareShadowResponse compares the response of the server with the cached
+// response of the same cache key in shadow mode, like verifyCachedResponse.
+// A different cached response is recorded as stale, since the cache would have
+// served it instead. It returns whether a response is cached, in which case it
+// is kept as is, so that the cache expires and is invalidated as if it served
+// the responses.
+func (p *Plugin) compareShadowResponse(ctx context.Context, write *cacheWrite) bool {
+	cached, err := p.RedisClient.Get(ctx, p.responseKey(write.cacheKey)).Bytes()
+	CacheGetsCounter.Inc()
+	if errors.Is(err, goRedis.Nil) {
+		return false
+	} else if err != nil {
+		CacheErrorsCounter.Inc()
+		p.Logger.Debug("Failed to get cached response", "error", err)
+		return false
+	}
+
+	if responsesMatch(cached, write.response, write.query) {
+		return true
+	}
+
+	cluster, database, request, _ := parseCacheKey(write.cacheKey)
+	query, fingerprint := describeRequest(request)
+	stale := StaleResponse{
+		Cluster:     cluster,
+		Database:    database,
+		Query:       query,
+		Fingerprint: fingerprint,
+		Tables:      write.tables,
+		CachedBytes: len(cached),
+		FreshBytes:  len(write.response),
+		Time:        time.Now(),
+	}
+	if stale.Tables == nil {
+		stale.Tables = []string{}
+	}
+
+	p.countPerTable(CacheStaleResponsesCounter, database, write.tables)
+	if p.StaleResponses != nil {
+		p.StaleResponses.Add(stale)
+	}
+	trace.SpanFromContext(ctx).AddEvent("stale response", trace.WithAttributes(
+		CacheFingerprintAttribute.String(fingerprint),
+		CacheTablesAttribute.StringSlice(write.tables)))
+	p.Logger.Warn("The cache would have served a stale response",
+		"database", database, "fingerprint", fingerprint, "tables", write.tables,
+		"cachedBytes", stale.CachedBytes, "freshBytes", stale.FreshBytes)
+
+	return true
+}
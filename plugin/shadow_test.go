@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	sdkAct "github.com/gatewayd-io/gatewayd-plugin-sdk/act"
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShadowMode(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	p.DefaultDBName = "postgres"
+	p.ShadowMode = true
+	p.StaleResponses = NewStaleResponses(DefaultStaleResponsesCapacity)
+	ctx := context.Background()
+
+	query, request := testQueryRequest()
+	cacheKey := populateCache(t, p, redisClient, "localhost:5432", "postgres", query, "users")
+
+	// The cache hit is counted, but the request is sent to the server.
+	shadowHits := testutil.ToFloat64(CacheShadowHitsCounter)
+	hits := testutil.ToFloat64(CacheHitsCounter)
+	req, err := v1.NewStruct(map[string]interface{}{
+		"request": request,
+		"server": map[string]interface{}{
+			"remote": "localhost:5432",
+		},
+	})
+	assert.Nil(t, err)
+	result, err := p.OnTrafficFromClient(ctx, req)
+	assert.Nil(t, err)
+	assert.NotContains(t, result.GetFields(), sdkAct.Signals)
+	assert.NotContains(t, result.GetFields(), "response")
+	assert.InDelta(t, shadowHits+1, testutil.ToFloat64(CacheShadowHitsCounter), 0)
+	assert.InDelta(t, hits, testutil.ToFloat64(CacheHitsCounter), 0)
+
+	// The same response is not stale, and the cached one is kept.
+	p.writeCache(ctx, &cacheWrite{
+		cacheKey: cacheKey, response: []byte("response:" + query), tables: []string{"users"},
+	})
+	total, _ := p.StaleResponses.Recent()
+	assert.Equal(t, int64(0), total)
+
+	// A different response is stale, and the cached one is still kept, as
+	// the cache would have kept serving it.
+	stale := testutil.ToFloat64(CacheStaleResponsesCounter.WithLabelValues("postgres", "users"))
+	p.writeCache(ctx, &cacheWrite{
+		cacheKey: cacheKey, response: []byte("fresh"), tables: []string{"users"},
+	})
+	total, recent := p.StaleResponses.Recent()
+	assert.Equal(t, int64(1), total)
+	assert.Len(t, recent, 1)
+	assert.Equal(t, "postgres", recent[0].Database)
+	assert.Equal(t, query, recent[0].Query)
+	assert.Len(t, recent[0].Fingerprint, FingerprintLength)
+	assert.Equal(t, []string{"users"}, recent[0].Tables)
+	assert.Equal(t, len("response:"+query), recent[0].CachedBytes)
+	assert.Equal(t, len("fresh"), recent[0].FreshBytes)
+	assert.InDelta(t, stale+1, testutil.ToFloat64(
+		CacheStaleResponsesCounter.WithLabelValues("postgres", "users")), 0)
+	assert.Equal(t, "response:"+query, redisClient.Get(ctx, p.responseKey(cacheKey)).Val())
+
+	// Responses that are not cached yet are cached as usual.
+	p.writeCache(ctx, &cacheWrite{cacheKey: cacheKey + "2", response: []byte("new")})
+	assert.Equal(t, "new", redisClient.Get(ctx, p.responseKey(cacheKey+"2")).Val())
+}
+
+func TestStaleResponses(t *testing.T) {
+	staleResponses := NewStaleResponses(2)
+	staleResponses.Add(StaleResponse{Query: "1"})
+	staleResponses.Add(StaleResponse{Query: "2"})
+	staleResponses.Add(StaleResponse{Query: "3"})
+
+	total, recent := staleResponses.Recent()
+	assert.Equal(t, int64(3), total)
+	assert.Equal(t, []StaleResponse{{Query: "3"}, {Query: "2"}}, recent)
+}
+
+func TestShadowModeUncacheableResponse(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	p.DefaultDBName = "postgres"
+	p.ShadowMode = true
+	p.StaleResponses = NewStaleResponses(DefaultStaleResponsesCapacity)
+	ctx := context.Background()
+
+	query, request := testQueryRequest()
+	cacheKey := populateCache(t, p, redisClient, "localhost:5432", "postgres", query, "users")
+
+	// The rows were deleted, so the empty result set is compared with the cached
+	// response, even though it is not cached.
+	serverResponse, err := v1.NewStruct(map[string]interface{}{
+		"request":  request,
+		"response": encodeResponse(t),
+		"server": map[string]interface{}{
+			"remote": "localhost:5432",
+		},
+	})
+	assert.Nil(t, err)
+	write := p.prepareCacheWrite(ctx, serverResponse)
+	assert.NotNil(t, write)
+	assert.True(t, write.uncacheable)
+
+	p.writeCache(ctx, write)
+	total, _ := p.StaleResponses.Recent()
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, "response:"+query, redisClient.Get(ctx, p.responseKey(cacheKey)).Val())
+}
@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"context"
+)
+
+// DefaultShutdownTimeout is how long the queued server responses are drained on shutdown.
+const DefaultShutdownTimeout = "5s"
+
+// Shutdown stops the admin API and the scheduled jobs, and waits for the queued
+// server responses to be cached, until the context is done. Server responses
+// received after Shutdown is called are not cached. The Redis client is not
+// closed, as the context used by UpdateCache should be cancelled first.
+func (p *Plugin) Shutdown(ctx context.Context) error {
+	if p.stop != nil {
+		p.stop()
+	}
+
+	if err := p.shutdownAdminAPI(ctx); err != nil {
+		p.Logger.Warn("Failed to stop the admin API before the deadline", "error", err)
+	}
+
+	if p.scheduler != nil {
+		// This waits for running jobs to finish, which are cancelled by stop,
+		// but not beyond the deadline.
+		stopped := make(chan struct{})
+		go func() {
+			p.scheduler.Stop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+			p.Logger.Debug("Stopped scheduled jobs")
+		case <-ctx.Done():
+			p.Logger.Warn("Failed to stop the scheduled jobs before the deadline")
+			return ctx.Err()
+		}
+	}
+
+	if p.UpdateCacheChannel == nil {
+		return nil
+	}
+
+	p.closeUpdateCacheChannel()
+
+	drained := make(chan struct{})
+	go func() {
+		p.WaitGroup.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		p.Logger.Debug("Cached all the queued server responses")
+		return nil
+	case <-ctx.Done():
+		p.Logger.Warn("Failed to cache all the queued server responses before the deadline",
+			"responses", len(p.UpdateCacheChannel))
+		return ctx.Err()
+	}
+}
+
+// stoppingContext returns a context that is cancelled once Shutdown is called,
+// so that the background work using it does not hold off the shutdown.
+func (p *Plugin) stoppingContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-p.stopping:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// shutdownAdminAPI stops the admin API from accepting requests, and waits for the
+// running ones until the context is done.
+func (p *Plugin) shutdownAdminAPI(ctx context.Context) error {
+	if p.adminMutex == nil {
+		return nil
+	}
+
+	p.adminMutex.Lock()
+	server := p.adminServer
+	p.adminMutex.Unlock()
+	if server == nil {
+		return nil
+	}
+
+	if err := server.Shutdown(ctx); err != nil {
+		return err
+	}
+	p.Logger.Debug("Stopped admin API server")
+	return nil
+}
+
+// closeUpdateCacheChannel closes UpdateCacheChannel once no server response is being sent to it.
+// The responses waiting for room in the channel were dropped by stop, as they would keep the
+// channel from being closed for as long as the cache writers are stalled.
+func (p *Plugin) closeUpdateCacheChannel() {
+	if p.updateCacheMutex != nil {
+		p.updateCacheMutex.Lock()
+		defer p.updateCacheMutex.Unlock()
+	}
+
+	if !p.updateCacheClosed {
+		p.updateCacheClosed = true
+		close(p.UpdateCacheChannel)
+	}
+}
@@ -0,0 +1,158 @@
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdownDrainsQueuedResponses(t *testing.T) {
+	p, redisClient := newTestPlugin(t)
+	p.Impl.PeriodicInvalidatorInterval = time.Minute
+	p.Impl.PeriodicInvalidatorStartDelay = time.Minute
+	ctx := context.Background()
+
+	p.Impl.PeriodicInvalidator(ctx)
+	p.Impl.WaitGroup.Add(1)
+	go p.Impl.UpdateCache(ctx)
+
+	redisClient.Set(ctx, "gwc:v1:session:tcp:localhost:45320", "postgres", 0)
+
+	_, request := testQueryRequest()
+	response, _ := base64.StdEncoding.DecodeString(
+		"VAAAABsAAWlkAAAAQAQAAQAAABcABP////8AAEQAAAALAAEAAAABMUMAAAANU0VMRUNUIDEAWgAAAAVJ")
+	args := map[string]interface{}{
+		"request":  request,
+		"response": response,
+		"client": map[string]interface{}{
+			"remote": "localhost:45320",
+		},
+		"server": map[string]interface{}{
+			"remote": "localhost:5432",
+		},
+	}
+	resp, _ := v1.NewStruct(args)
+	_, err := p.Impl.OnTrafficFromServer(ctx, resp)
+	assert.Nil(t, err)
+
+	// Parsing the first query compiles the query parser, which takes a while.
+	drainCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	assert.Nil(t, p.Impl.Shutdown(drainCtx))
+	assert.False(t, p.Impl.scheduler.IsRunning())
+
+	cachedResponse, err := redisClient.Get(
+		ctx, "gwc:v1:resp:{localhost:5432}:postgres:"+string(request)).Bytes()
+	assert.Nil(t, err)
+	assert.Equal(t, response, cachedResponse)
+
+	// Server responses received after shutdown are not cached.
+	_, err = p.Impl.OnTrafficFromServer(ctx, resp)
+	assert.Nil(t, err)
+	// Shutting down twice is safe.
+	assert.Nil(t, p.Impl.Shutdown(drainCtx))
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	p, _ := newTestPlugin(t)
+
+	// Nothing consumes the queued responses.
+	p.Impl.WaitGroup.Add(1)
+	defer p.Impl.WaitGroup.Done()
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, p.Impl.Shutdown(drainCtx), context.DeadlineExceeded)
+}
+
+func TestUpdateCacheStopsOnCancel(t *testing.T) {
+	p, _ := newTestPlugin(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p.Impl.WaitGroup.Add(1)
+	go p.Impl.UpdateCache(ctx)
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		p.Impl.WaitGroup.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("UpdateCache did not return after the context was cancelled")
+	}
+}
+
+func TestShutdownWithBlockedResponse(t *testing.T) {
+	p, _ := newTestPlugin(t)
+	p.Impl.OverflowPolicy = OverflowBlock
+	p.Impl.UpdateCacheChannel = make(chan *v1.Struct, 1)
+	dropped := testutil.ToFloat64(CacheDroppedResponsesCounter.WithLabelValues(string(OverflowBlock)))
+
+	// Nothing consumes the queued responses, so the second one waits for room.
+	p.Impl.enqueueServerResponse(serverResponse("first"))
+	done := make(chan struct{})
+	go func() {
+		p.Impl.enqueueServerResponse(serverResponse("second"))
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		if p.Impl.updateCacheMutex.TryLock() {
+			p.Impl.updateCacheMutex.Unlock()
+			return false
+		}
+		return true
+	}, time.Second, time.Millisecond)
+
+	// The waiting response is dropped, so that the channel can be closed.
+	drainCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, p.Impl.Shutdown(drainCtx))
+	<-done
+	assert.Equal(t, dropped+1, testutil.ToFloat64(CacheDroppedResponsesCounter.WithLabelValues(string(OverflowBlock))))
+	assert.Equal(t, "first", (<-p.Impl.UpdateCacheChannel).GetFields()["name"].GetStringValue())
+}
+
+func TestShutdownStopsAdminAPI(t *testing.T) {
+	p, _ := newTestPlugin(t)
+	socket := filepath.Join(t.TempDir(), "admin.sock")
+
+	stopped := make(chan struct{})
+	go func() {
+		p.Impl.ExposeAdminAPI(socket)
+		close(stopped)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	get := func() error {
+		response, err := client.Get("http://admin/stale-responses")
+		if err == nil {
+			response.Body.Close()
+		}
+		return err
+	}
+	assert.Eventually(t, func() bool { return get() == nil }, time.Second, 10*time.Millisecond)
+
+	// The admin API doesn't accept requests, e.g. flushes, once Shutdown is called.
+	drainCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, p.Impl.Shutdown(drainCtx))
+	<-stopped
+	client.CloseIdleConnections()
+	assert.Error(t, get())
+}
@@ -0,0 +1,250 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"time"
+
+	goRedis "github.com/redis/go-redis/v9"
+)
+
+// SnapshotFormat identifies snapshot files and the version of their layout.
+const SnapshotFormat = "gatewayd-plugin-cache-snapshot/v1"
+
+// TTLRebase decides the TTL of the imported responses.
+type TTLRebase string
+
+const (
+	// TTLRebaseKeep keeps the TTL the responses had when they were exported.
+	TTLRebaseKeep TTLRebase = "keep"
+	// TTLRebaseElapsed subtracts the time elapsed since the export from the TTL,
+	// so the responses expire when they would have, and skips the expired ones.
+	TTLRebaseElapsed TTLRebase = "elapsed"
+	// TTLRebaseExpiry sets the TTL to the expiry of the plugin.
+	TTLRebaseExpiry TTLRebase = "expiry"
+)
+
+// ParseTTLRebase parses a TTL rebase mode.
+func ParseTTLRebase(value string) (TTLRebase, error) {
+	switch mode := TTLRebase(value); mode {
+	case TTLRebaseKeep, TTLRebaseElapsed, TTLRebaseExpiry:
+		return mode, nil
+	default:
+		return "", ErrInvalidTTLRebase
+	}
+}
+
+// SnapshotHeader is the first line of a snapshot.
+type SnapshotHeader struct {
+	Format     string    `json:"format"`
+	KeySchema  string    `json:"keySchema"`
+	ExportedAt time.Time `json:"exportedAt"`
+}
+
+// SnapshotEntry is a cached response, along with the tables it depends on and
+// the tags it is invalidated with.
+// Cache keys are independent of the key prefix, and contain the raw request
+// unless normalized cache keys are enabled, so they are base64 encoded, like
+// the response.
+type SnapshotEntry struct {
+	CacheKey []byte   `json:"cacheKey"`
+	Cluster  string   `json:"cluster"`
+	Database string   `json:"database"`
+	Tables   []string `json:"tables"`
+	Tags     []string `json:"tags,omitempty"`
+	Response []byte   `json:"response"`
+	// TTLMillis is the TTL of the response when it was exported, in milliseconds,
+	// or -1 if it never expires.
+	TTLMillis int64 `json:"ttlMillis"`
+}
+
+// SnapshotFilter selects the entries of a snapshot. Empty fields match all entries.
+type SnapshotFilter struct {
+	Database string
+	Table    string
+}
+
+func (f SnapshotFilter) matches(entry *SnapshotEntry) bool {
+	return (f.Database == "" || entry.Database == f.Database) &&
+		(f.Table == "" || slices.Contains(entry.Tables, f.Table))
+}
+
+// ExportSnapshot writes the cached responses matching the filter, along with
+// their table and tag indexes and TTLs, to w in JSON Lines format: a SnapshotHeader
+// followed by a SnapshotEntry per response. Sessions are not exported, and
+// neither are index keys whose response does not exist anymore. It returns the
+// number of exported responses.
+func (p *Plugin) ExportSnapshot(ctx context.Context, w io.Writer, filter SnapshotFilter) (int, error) {
+	// The index keys are gathered first, so that they can be exported along
+	// with their response.
+	tables := map[string][]string{}
+	err := p.scanKeys(ctx, p.namespace(TableIndexNamespace)+"*", func(keys []string) {
+		for _, key := range keys {
+			if table, cacheKey, ok := p.parseTableIndexKey(key); ok {
+				tables[cacheKey] = append(tables[cacheKey], table)
+			}
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	tags := map[string][]string{}
+	err = p.scanKeys(ctx, p.namespace(TagIndexNamespace)+"*", func(keys []string) {
+		for _, key := range keys {
+			if tag, cacheKey, ok := p.parseTagIndexKey(key); ok {
+				tags[cacheKey] = append(tags[cacheKey], tag)
+			}
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+	if err := encoder.Encode(SnapshotHeader{
+		Format:     SnapshotFormat,
+		KeySchema:  KeySchemaVersion,
+		ExportedAt: time.Now().UTC(),
+	}); err != nil {
+		return 0, err
+	}
+
+	exported := 0
+	var exportErr error
+	err = p.scanKeys(ctx, p.namespace(ResponseNamespace)+"*", func(keys []string) {
+		if exportErr != nil {
+			return
+		}
+
+		pipeline := p.RedisClient.Pipeline()
+		responses := make([]*goRedis.StringCmd, len(keys))
+		ttls := make([]*goRedis.DurationCmd, len(keys))
+		for i, key := range keys {
+			responses[i] = pipeline.Get(ctx, key)
+			ttls[i] = pipeline.PTTL(ctx, key)
+		}
+		// Responses that expire during the export are skipped below.
+		if _, err := pipeline.Exec(ctx); err != nil && !errors.Is(err, goRedis.Nil) {
+			CacheErrorsCounter.Inc()
+			exportErr = err
+			return
+		}
+
+		for i, key := range keys {
+			cacheKey := key[len(p.namespace(ResponseNamespace)):]
+			cluster, database, _, ok := parseCacheKey(cacheKey)
+			response, err := responses[i].Bytes()
+			ttl := ttls[i].Val()
+			if !ok || err != nil || ttl == keyMissingTTL || (ttl >= 0 && ttl < time.Millisecond) {
+				continue
+			}
+
+			entry := &SnapshotEntry{
+				CacheKey:  []byte(cacheKey),
+				Cluster:   cluster,
+				Database:  database,
+				Tables:    tables[cacheKey],
+				Tags:      tags[cacheKey],
+				Response:  response,
+				TTLMillis: ttl.Milliseconds(),
+			}
+			if entry.Tables == nil {
+				entry.Tables = []string{}
+			}
+			if ttl < 0 {
+				entry.TTLMillis = -1
+			}
+			if !filter.matches(entry) {
+				continue
+			}
+
+			if exportErr = encoder.Encode(entry); exportErr != nil {
+				return
+			}
+			exported++
+		}
+	})
+	if err != nil {
+		return exported, err
+	}
+	if exportErr != nil {
+		return exported, exportErr
+	}
+
+	return exported, writer.Flush()
+}
+
+// ImportSnapshot caches the responses of a snapshot matching the filter, along
+// with their table and tag indexes, under the key prefix of the plugin. The TTL of the
+// responses is rebased as set. It returns the number of imported responses.
+func (p *Plugin) ImportSnapshot(
+	ctx context.Context, r io.Reader, filter SnapshotFilter, rebase TTLRebase,
+) (int, error) {
+	decoder := json.NewDecoder(r)
+	var header SnapshotHeader
+	if err := decoder.Decode(&header); err != nil || header.Format != SnapshotFormat {
+		return 0, ErrInvalidSnapshot
+	}
+	elapsed := time.Since(header.ExportedAt)
+
+	imported := 0
+	for {
+		var entry SnapshotEntry
+		if err := decoder.Decode(&entry); errors.Is(err, io.EOF) {
+			return imported, nil
+		} else if err != nil {
+			return imported, ErrInvalidSnapshot
+		}
+
+		// The cluster and the database are taken from the cache key, which is
+		// what the plugin looks up.
+		cacheKey := string(entry.CacheKey)
+		var ok bool
+		if entry.Cluster, entry.Database, _, ok = parseCacheKey(cacheKey); !ok {
+			return imported, ErrInvalidSnapshot
+		}
+		if !filter.matches(&entry) {
+			continue
+		}
+		if slices.ContainsFunc(entry.Tags, func(tag string) bool { return !isValidTag(tag) }) {
+			return imported, ErrInvalidSnapshot
+		}
+
+		// Responses without expiry are imported without expiry, unless reset.
+		var ttl time.Duration
+		switch {
+		case rebase == TTLRebaseExpiry:
+			ttl = p.Expiry
+		case entry.TTLMillis < 0:
+			ttl = 0
+		case rebase == TTLRebaseElapsed:
+			ttl = time.Duration(entry.TTLMillis)*time.Millisecond - elapsed
+			if ttl <= 0 {
+				continue
+			}
+		default:
+			ttl = time.Duration(entry.TTLMillis) * time.Millisecond
+		}
+
+		pipeline := p.RedisClient.TxPipeline()
+		pipeline.Set(ctx, p.responseKey(cacheKey), entry.Response, ttl)
+		for _, table := range entry.Tables {
+			pipeline.Set(ctx, p.tableIndexKey(table, cacheKey), "", ttl)
+		}
+		for _, tag := range entry.Tags {
+			pipeline.Set(ctx, p.tagIndexKey(tag, cacheKey), "", ttl)
+		}
+		if _, err := pipeline.Exec(ctx); err != nil {
+			CacheErrorsCounter.Inc()
+			return imported, err
+		}
+		CacheSetsCounter.Add(float64(1 + len(entry.Tables) + len(entry.Tags)))
+		imported++
+	}
+}
@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	source, sourceRedis := newTestPlugin(t)
+	ctx := context.Background()
+
+	users := populateCache(
+		t, &source.Impl, sourceRedis, "localhost:5432", "postgres", "SELECT * FROM users", "users")
+	joined := populateCache(t, &source.Impl, sourceRedis, "localhost:5432", "postgres",
+		"SELECT * FROM users JOIN posts ON users.id = posts.user_id", "users", "posts")
+	other := populateCache(
+		t, &source.Impl, sourceRedis, "localhost:5432", "other", "SELECT 1")
+	sourceRedis.Persist(ctx, source.Impl.responseKey(other))
+	// Index keys without a response are not exported.
+	sourceRedis.Set(ctx, source.Impl.tableIndexKey("users", "{localhost:5432}:postgres:gone"), "", time.Hour)
+
+	var snapshot bytes.Buffer
+	exported, err := source.Impl.ExportSnapshot(ctx, &snapshot, SnapshotFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, exported)
+
+	var filtered bytes.Buffer
+	exported, err = source.Impl.ExportSnapshot(ctx, &filtered, SnapshotFilter{Table: "posts"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, exported)
+
+	// The snapshot is imported under another key prefix.
+	target, targetRedis := newTestPlugin(t)
+	target.Impl.KeyPrefix = "other"
+	imported, err := target.Impl.ImportSnapshot(
+		ctx, bytes.NewReader(snapshot.Bytes()), SnapshotFilter{Database: "postgres"}, TTLRebaseKeep)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, imported)
+
+	for _, cacheKey := range []string{users, joined} {
+		assert.Equal(t,
+			sourceRedis.Get(ctx, source.Impl.responseKey(cacheKey)).Val(),
+			targetRedis.Get(ctx, target.Impl.responseKey(cacheKey)).Val())
+		assert.InDelta(t, time.Hour.Seconds(),
+			targetRedis.TTL(ctx, target.Impl.responseKey(cacheKey)).Val().Seconds(), 5)
+	}
+	assert.Equal(t, int64(3), targetRedis.Exists(ctx,
+		target.Impl.tableIndexKey("users", users),
+		target.Impl.tableIndexKey("users", joined),
+		target.Impl.tableIndexKey("posts", joined)).Val())
+	assert.Equal(t, int64(0), targetRedis.Exists(ctx, target.Impl.responseKey(other)).Val())
+
+	// The imported responses are invalidated like the cached ones.
+	assert.Equal(t, 4, target.Impl.InvalidateTable(ctx, "users"))
+
+	// Responses without expiry are imported without expiry.
+	imported, err = target.Impl.ImportSnapshot(
+		ctx, bytes.NewReader(snapshot.Bytes()), SnapshotFilter{Database: "other"}, TTLRebaseElapsed)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, imported)
+	assert.Equal(t, time.Duration(-1), targetRedis.TTL(ctx, target.Impl.responseKey(other)).Val())
+
+	// The time elapsed since the export is subtracted from the TTL.
+	lines := strings.SplitN(snapshot.String(), "\n", 2)
+	header, err := json.Marshal(SnapshotHeader{
+		Format:     SnapshotFormat,
+		KeySchema:  KeySchemaVersion,
+		ExportedAt: time.Now().Add(-30 * time.Minute),
+	})
+	assert.Nil(t, err)
+	imported, err = target.Impl.ImportSnapshot(ctx, strings.NewReader(string(header)+"\n"+lines[1]),
+		SnapshotFilter{Table: "posts"}, TTLRebaseElapsed)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, imported)
+	assert.InDelta(t, (30 * time.Minute).Seconds(),
+		targetRedis.TTL(ctx, target.Impl.responseKey(joined)).Val().Seconds(), 5)
+
+	// Or the TTL is reset to the expiry.
+	target.Impl.Expiry = 2 * time.Hour
+	_, err = target.Impl.ImportSnapshot(
+		ctx, bytes.NewReader(filtered.Bytes()), SnapshotFilter{}, TTLRebaseExpiry)
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Hour, targetRedis.TTL(ctx, target.Impl.responseKey(joined)).Val())
+
+	_, err = target.Impl.ImportSnapshot(ctx, strings.NewReader("{}"), SnapshotFilter{}, TTLRebaseKeep)
+	assert.ErrorIs(t, err, ErrInvalidSnapshot)
+	_, err = ParseTTLRebase("never")
+	assert.ErrorIs(t, err, ErrInvalidTTLRebase)
+}
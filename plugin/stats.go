@@ -0,0 +1,233 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	goRedis "github.com/redis/go-redis/v9"
+)
+
+// NoExpiryBucket is the TTL histogram bucket of keys without expiry.
+const NoExpiryBucket = "none"
+
+// TTLBuckets are the upper bounds of the TTL histogram buckets.
+var TTLBuckets = []time.Duration{
+	time.Minute,
+	10 * time.Minute,
+	time.Hour,
+	24 * time.Hour,
+}
+
+// TTLBucketLabels returns the labels of the TTL histogram buckets, in order.
+func TTLBucketLabels() []string {
+	labels := make([]string, 0, len(TTLBuckets)+2)
+	for _, bucket := range TTLBuckets {
+		labels = append(labels, "<"+formatBucket(bucket))
+	}
+	labels = append(labels, ">="+formatBucket(TTLBuckets[len(TTLBuckets)-1]), NoExpiryBucket)
+	return labels
+}
+
+// KeyStats are the statistics of a group of cached responses.
+type KeyStats struct {
+	Keys         int64            `json:"keys"`
+	Bytes        int64            `json:"bytes"`
+	TTLHistogram map[string]int64 `json:"ttlHistogram"`
+}
+
+// CacheStats are the statistics of the cache per database and per table.
+type CacheStats struct {
+	Sessions  int64                `json:"sessions"`
+	Databases map[string]*KeyStats `json:"databases"`
+	Tables    map[string]*KeyStats `json:"tables"`
+}
+
+func (s *KeyStats) add(size int64, ttl time.Duration) {
+	if s.TTLHistogram == nil {
+		s.TTLHistogram = map[string]int64{}
+	}
+
+	s.Keys++
+	s.Bytes += size
+	s.TTLHistogram[ttlBucket(ttl)]++
+}
+
+// Stats returns the number of keys, the number of bytes and the TTL histogram
+// of the cached responses per database and per table.
+func (p *Plugin) Stats(ctx context.Context) (*CacheStats, error) {
+	stats := &CacheStats{
+		Databases: map[string]*KeyStats{},
+		Tables:    map[string]*KeyStats{},
+	}
+
+	err := p.scanKeys(ctx, p.sessionKey("*"), func(keys []string) {
+		stats.Sessions += int64(len(keys))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.scanKeys(ctx, p.responseKey("*"), func(keys []string) {
+		sizes, ttls, err := p.sizesAndTTLs(ctx, keys)
+		if err != nil {
+			p.Logger.Debug("Failed to get the size of cached responses", "error", err)
+			return
+		}
+
+		for i, key := range keys {
+			_, database, _, ok := parseCacheKey(strings.TrimPrefix(key, p.responseKey("")))
+			if !ok || ttls[i] == keyMissingTTL {
+				continue
+			}
+			if stats.Databases[database] == nil {
+				stats.Databases[database] = &KeyStats{}
+			}
+			stats.Databases[database].add(sizes[i], ttls[i])
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.scanKeys(ctx, p.namespace(TableIndexNamespace)+"*", func(keys []string) {
+		tables := make([]string, 0, len(keys))
+		responseKeys := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if table, cacheKey, ok := p.parseTableIndexKey(key); ok {
+				tables = append(tables, table)
+				responseKeys = append(responseKeys, p.responseKey(cacheKey))
+			}
+		}
+
+		sizes, ttls, err := p.sizesAndTTLs(ctx, responseKeys)
+		if err != nil {
+			p.Logger.Debug("Failed to get the size of cached responses", "error", err)
+			return
+		}
+
+		for i, table := range tables {
+			// Orphaned index keys are not counted.
+			if ttls[i] == keyMissingTTL {
+				continue
+			}
+			if stats.Tables[table] == nil {
+				stats.Tables[table] = &KeyStats{}
+			}
+			stats.Tables[table].add(sizes[i], ttls[i])
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// PurgeOrphanedIndexKeys deletes the table and tag index keys whose cached
+// response does not exist anymore, and returns the number of deleted keys.
+func (p *Plugin) PurgeOrphanedIndexKeys(ctx context.Context) (int, error) {
+	deleted, err := p.purgeOrphanedIndexKeys(ctx, TableIndexNamespace, p.parseTableIndexKey)
+	if err != nil {
+		return deleted, err
+	}
+
+	tagDeleted, err := p.purgeOrphanedIndexKeys(ctx, TagIndexNamespace, p.parseTagIndexKey)
+	return deleted + tagDeleted, err
+}
+
+// deleteOrphanedIndexKeyScript deletes the index key in KEYS[1] if the response
+// in KEYS[2] does not exist. Checking and deleting atomically keeps the purge
+// from deleting the index key of a response that was just cached again. Both
+// keys have the hash tag of the cluster, so this also works on Redis Cluster.
+var deleteOrphanedIndexKeyScript = goRedis.NewScript(`
+if redis.call("EXISTS", KEYS[2]) == 0 then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// purgeOrphanedIndexKeys deletes the orphaned index keys of the namespace, whose
+// keys are split into their table or tag and cache key by parse. Each batch of
+// keys returned by SCAN is purged before the next one is scanned.
+func (p *Plugin) purgeOrphanedIndexKeys(
+	ctx context.Context, namespace string, parse func(indexKey string) (string, string, bool),
+) (int, error) {
+	deleted := 0
+	err := p.scanKeys(ctx, p.namespace(namespace)+"*", func(keys []string) {
+		pipeline := p.RedisClient.Pipeline()
+		results := make([]*goRedis.Cmd, 0, len(keys))
+		for _, key := range keys {
+			if _, cacheKey, ok := parse(key); ok {
+				results = append(results, deleteOrphanedIndexKeyScript.Eval(
+					ctx, pipeline, []string{key, p.responseKey(cacheKey)}))
+			}
+		}
+
+		if _, err := pipeline.Exec(ctx); err != nil {
+			p.Logger.Debug("Failed to purge orphaned index keys", "error", err)
+		}
+		for _, result := range results {
+			if count, err := result.Int(); err != nil {
+				CacheErrorsCounter.Inc()
+			} else if count > 0 {
+				CacheDeletesCounter.Inc()
+				deleted += count
+			}
+		}
+	})
+
+	return deleted, err
+}
+
+// sizesAndTTLs returns the size and the TTL of each key.
+func (p *Plugin) sizesAndTTLs(ctx context.Context, keys []string) ([]int64, []time.Duration, error) {
+	pipeline := p.RedisClient.Pipeline()
+	sizeCmds := make([]*goRedis.IntCmd, len(keys))
+	ttlCmds := make([]*goRedis.DurationCmd, len(keys))
+	for i, key := range keys {
+		sizeCmds[i] = pipeline.StrLen(ctx, key)
+		ttlCmds[i] = pipeline.PTTL(ctx, key)
+	}
+
+	if _, err := pipeline.Exec(ctx); err != nil {
+		CacheErrorsCounter.Inc()
+		return nil, nil, err
+	}
+
+	sizes := make([]int64, len(keys))
+	ttls := make([]time.Duration, len(keys))
+	for i := range keys {
+		sizes[i] = sizeCmds[i].Val()
+		ttls[i] = ttlCmds[i].Val()
+	}
+
+	return sizes, ttls, nil
+}
+
+// ttlBucket returns the label of the TTL histogram bucket of a TTL.
+func ttlBucket(ttl time.Duration) string {
+	if ttl < 0 {
+		return NoExpiryBucket
+	}
+
+	for _, bucket := range TTLBuckets {
+		if ttl < bucket {
+			return "<" + formatBucket(bucket)
+		}
+	}
+
+	return ">=" + formatBucket(TTLBuckets[len(TTLBuckets)-1])
+}
+
+// formatBucket formats a bucket bound without its zero components, e.g. "10m" or "24h".
+func formatBucket(bound time.Duration) string {
+	formatted := bound.String()
+	if strings.HasSuffix(formatted, "m0s") {
+		formatted = strings.TrimSuffix(formatted, "0s")
+	}
+	if strings.HasSuffix(formatted, "h0m") {
+		formatted = strings.TrimSuffix(formatted, "0m")
+	}
+	return formatted
+}
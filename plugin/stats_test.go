@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	ctx := context.Background()
+
+	populateCache(t, p, redisClient, "localhost:5432", "postgres", "SELECT * FROM users", "users")
+	populateCache(t, p, redisClient, "localhost:5432", "postgres",
+		"SELECT * FROM users JOIN posts ON users.id = posts.user_id", "users", "posts")
+	populateCache(t, p, redisClient, "localhost:5432", "other", "SELECT 1")
+	redisClient.Set(ctx, p.sessionKey("tcp:localhost:45320"), "postgres", 0)
+	// An orphaned index key is not counted.
+	redisClient.Set(ctx, p.tableIndexKey("users", "{localhost:5432}:postgres:gone"), "", time.Hour)
+
+	stats, err := p.Stats(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), stats.Sessions)
+
+	assert.Len(t, stats.Databases, 2)
+	assert.Equal(t, int64(2), stats.Databases["postgres"].Keys)
+	assert.Equal(t, int64(2), stats.Databases["postgres"].TTLHistogram["<24h"])
+	assert.Equal(t, int64(len("response:SELECT 1")), stats.Databases["other"].Bytes)
+
+	assert.Len(t, stats.Tables, 2)
+	assert.Equal(t, int64(2), stats.Tables["users"].Keys)
+	assert.Equal(t, int64(1), stats.Tables["posts"].Keys)
+}
+
+func TestPurgeOrphanedIndexKeys(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	ctx := context.Background()
+
+	cacheKey := populateCache(t, p, redisClient, "localhost:5432", "postgres", "SELECT * FROM users", "users")
+	orphan := p.tableIndexKey("users", "{localhost:5432}:postgres:gone")
+	redisClient.Set(ctx, orphan, "", time.Hour)
+
+	deleted, err := p.PurgeOrphanedIndexKeys(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, int64(0), redisClient.Exists(ctx, orphan).Val())
+	assert.Equal(t, int64(2), redisClient.Exists(
+		ctx, p.responseKey(cacheKey), p.tableIndexKey("users", cacheKey)).Val())
+}
+
+func Test_ttlBucket(t *testing.T) {
+	assert.Equal(t, []string{"<1m", "<10m", "<1h", "<24h", ">=24h", "none"}, TTLBucketLabels())
+	assert.Equal(t, "<1m", ttlBucket(30*time.Second))
+	assert.Equal(t, "<10m", ttlBucket(time.Minute))
+	assert.Equal(t, "<24h", ttlBucket(time.Hour))
+	assert.Equal(t, ">=24h", ttlBucket(48*time.Hour))
+	assert.Equal(t, "none", ttlBucket(-1))
+}
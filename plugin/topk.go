@@ -0,0 +1,262 @@
+package plugin
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTopQueriesCapacity is the number of queries tracked by TopQueries if none is configured.
+	DefaultTopQueriesCapacity = 1000
+	// DefaultTopQueriesMetrics is the number of top queries exported as metrics.
+	DefaultTopQueriesMetrics = 10
+
+	// Orders of the top queries.
+	SortByLookups = "lookups"
+	SortByHits    = "hits"
+	SortByMisses  = "misses"
+	SortByBytes   = "bytes"
+)
+
+// QueryStats are the statistics of a query tracked by TopQueries.
+type QueryStats struct {
+	Cluster     string `json:"cluster"`
+	Database    string `json:"database"`
+	Query       string `json:"query,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Lookups is the estimated number of lookups of the query, which is at
+	// most Error more than the actual number.
+	Lookups int64 `json:"lookups"`
+	Error   int64 `json:"error"`
+	Hits    int64 `json:"hits"`
+	// RecentHits is the number of hits since the response was last cached.
+	RecentHits  int64     `json:"recentHits"`
+	Misses      int64     `json:"misses"`
+	Stores      int64     `json:"stores"`
+	BytesServed int64     `json:"bytesServed"`
+	LastSeen    time.Time `json:"lastSeen"`
+
+	cacheKey string
+	// expiry is the TTL the response was last cached with, if it was cached since
+	// the query was added.
+	expiry time.Duration
+	// request is the request of the query, if the cache key only has its fingerprint.
+	request string
+	index   int
+}
+
+// TopQueries keeps track of the most looked up queries in a fixed amount of
+// memory, using the space-saving algorithm: once Capacity queries are tracked,
+// a new query replaces the least looked up one and inherits its lookups as its
+// error. Frequent queries are never replaced, and the hits, misses, stores and
+// bytes served are exact since the query was last added.
+type TopQueries struct {
+	Capacity int
+
+	mutex   sync.Mutex
+	queries map[string]*QueryStats
+	lookups queryHeap
+	now     func() time.Time
+}
+
+// NewTopQueries returns a tracker of the top queries.
+func NewTopQueries(capacity int) *TopQueries {
+	if capacity <= 0 {
+		capacity = DefaultTopQueriesCapacity
+	}
+
+	return &TopQueries{
+		Capacity: capacity,
+		queries:  make(map[string]*QueryStats, capacity),
+		lookups:  make(queryHeap, 0, capacity),
+		now:      time.Now,
+	}
+}
+
+// RecordLookup records a lookup of the cache key of the request, and the size
+// of the response if it was a hit. Nothing is recorded if t is nil.
+func (t *TopQueries) RecordLookup(cacheKey, request string, hit bool, bytesServed int) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	query, ok := t.queries[cacheKey]
+	if !ok {
+		if len(t.lookups) < t.Capacity {
+			query = &QueryStats{}
+			heap.Push(&t.lookups, query)
+		} else {
+			// Replace the least looked up query.
+			query = t.lookups[0]
+			delete(t.queries, query.cacheKey)
+			*query = QueryStats{Lookups: query.Lookups, Error: query.Lookups, index: query.index}
+		}
+		query.cacheKey = cacheKey
+		// The request is kept if it cannot be recovered from the cache key.
+		if _, _, key, _ := parseCacheKey(cacheKey); isFingerprint(key) {
+			query.request = request
+		}
+		t.queries[cacheKey] = query
+	}
+
+	query.Lookups++
+	if hit {
+		query.Hits++
+		query.RecentHits++
+		query.BytesServed += int64(bytesServed)
+	} else {
+		query.Misses++
+	}
+	query.LastSeen = t.now()
+	heap.Fix(&t.lookups, query.index)
+}
+
+// RecordStore records that the response of the cache key was cached with the
+// expiry, if the key is tracked. The recent hits are counted from now on.
+func (t *TopQueries) RecordStore(cacheKey string, expiry time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if query, ok := t.queries[cacheKey]; ok {
+		query.Stores++
+		query.RecentHits = 0
+		query.expiry = expiry
+	}
+}
+
+// Top returns up to limit queries, sorted by lookups, hits, misses or bytes
+// served, in descending order. The queries are described from their cache keys.
+func (t *TopQueries) Top(limit int, sortBy string) []QueryStats {
+	t.mutex.Lock()
+	queries := make([]QueryStats, 0, len(t.queries))
+	for _, query := range t.queries {
+		queries = append(queries, *query)
+	}
+	t.mutex.Unlock()
+
+	value := func(query QueryStats) int64 {
+		switch sortBy {
+		case SortByHits:
+			return query.Hits
+		case SortByMisses:
+			return query.Misses
+		case SortByBytes:
+			return query.BytesServed
+		default:
+			return query.Lookups
+		}
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		return value(queries[i]) > value(queries[j])
+	})
+
+	if limit > 0 && len(queries) > limit {
+		queries = queries[:limit]
+	}
+
+	for i := range queries {
+		queries[i].Cluster, queries[i].Database, _, _ = parseCacheKey(queries[i].cacheKey)
+		queries[i].Query, queries[i].Fingerprint = describeRequest(queries[i].queryRequest())
+	}
+
+	return queries
+}
+
+// Hot returns the queries that were hits at least minHits times since their
+// responses were last cached, the most hit first, so a response that is not hit
+// anymore cools down once it is cached again. Unlike Top, the queries are not described.
+func (t *TopQueries) Hot(minHits int64) []QueryStats {
+	t.mutex.Lock()
+	var queries []QueryStats
+	for _, query := range t.queries {
+		if query.RecentHits >= minHits {
+			queries = append(queries, *query)
+		}
+	}
+	t.mutex.Unlock()
+
+	sort.Slice(queries, func(i, j int) bool {
+		return queries[i].RecentHits > queries[j].RecentHits
+	})
+	return queries
+}
+
+// queryRequest returns the request of the query.
+func (q *QueryStats) queryRequest() string {
+	if q.request != "" {
+		return q.request
+	}
+
+	_, _, request, _ := parseCacheKey(q.cacheKey)
+	return request
+}
+
+// TopQueriesSampler periodically exports the hits, misses and bytes served of
+// the top queries, by lookups, as metrics labeled by database and fingerprint.
+func (p *Plugin) TopQueriesSampler(interval time.Duration, limit int) {
+	if p.TopQueries == nil {
+		return
+	}
+
+	if err := p.schedule(interval, time.Now(), func() {
+		p.sampleTopQueries(limit)
+	}); err != nil {
+		p.Logger.Error("Failed to start top queries sampler",
+			"error", err, "interval", interval.String())
+		return
+	}
+
+	p.Logger.Debug("Started top queries sampler", "interval", interval.String())
+}
+
+// sampleTopQueries exports the top queries as metrics. Only the top queries are
+// labeled, so the number of series is bounded by the limit.
+func (p *Plugin) sampleTopQueries(limit int) {
+	TopQueryHitsGauge.Reset()
+	TopQueryMissesGauge.Reset()
+	TopQueryBytesServedGauge.Reset()
+
+	// Queries with the same fingerprint, e.g. on different servers, are summed up.
+	// Queries that cannot be fingerprinted share an empty fingerprint.
+	for _, query := range p.TopQueries.Top(limit, SortByLookups) {
+		labels := []string{query.Database, query.Fingerprint}
+		TopQueryHitsGauge.WithLabelValues(labels...).Add(float64(query.Hits))
+		TopQueryMissesGauge.WithLabelValues(labels...).Add(float64(query.Misses))
+		TopQueryBytesServedGauge.WithLabelValues(labels...).Add(float64(query.BytesServed))
+	}
+}
+
+// queryHeap is a min-heap of queries ordered by lookups.
+type queryHeap []*QueryStats
+
+func (h queryHeap) Len() int           { return len(h) }
+func (h queryHeap) Less(i, j int) bool { return h[i].Lookups < h[j].Lookups }
+
+func (h queryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *queryHeap) Push(x any) {
+	if query, ok := x.(*QueryStats); ok {
+		query.index = len(*h)
+		*h = append(*h, query)
+	}
+}
+
+func (h *queryHeap) Pop() any {
+	old := *h
+	query := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return query
+}
@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pgproto3 "github.com/jackc/pgx/v5/pgproto3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCacheKey returns the cache key of a simple query.
+func testCacheKey(t *testing.T, p *Plugin, query string) string {
+	t.Helper()
+	request, err := (&pgproto3.Query{String: query}).Encode(nil)
+	assert.Nil(t, err)
+	return p.getCacheKey("localhost:5432", "postgres", request)
+}
+
+func TestTopQueries(t *testing.T) {
+	plugin, _ := newTestPlugin(t)
+	p := &plugin.Impl
+	users := testCacheKey(t, p, "SELECT * FROM users")
+	posts := testCacheKey(t, p, "SELECT * FROM posts")
+	tags := testCacheKey(t, p, "SELECT * FROM tags")
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	topQueries := NewTopQueries(2)
+	topQueries.now = func() time.Time { return now }
+
+	topQueries.RecordLookup(users, "", false, 0)
+	topQueries.RecordStore(users, time.Hour)
+	topQueries.RecordLookup(users, "", true, 100)
+	topQueries.RecordLookup(users, "", true, 100)
+	topQueries.RecordLookup(posts, "", false, 0)
+	// The least looked up query is replaced, and its lookups become the error.
+	topQueries.RecordLookup(tags, "", true, 50)
+	topQueries.RecordStore(posts, time.Hour)
+
+	top := topQueries.Top(0, SortByLookups)
+	assert.Len(t, top, 2)
+	assert.Equal(t, "SELECT * FROM users", top[0].Query)
+	assert.Equal(t, "postgres", top[0].Database)
+	assert.Equal(t, QueryStats{
+		Cluster:     "localhost:5432",
+		Database:    "postgres",
+		Query:       "SELECT * FROM users",
+		Fingerprint: top[0].Fingerprint,
+		Lookups:     3,
+		Hits:        2,
+		RecentHits:  2,
+		Misses:      1,
+		Stores:      1,
+		BytesServed: 200,
+		LastSeen:    now,
+		cacheKey:    users,
+		expiry:      time.Hour,
+		index:       top[0].index,
+	}, top[0])
+
+	assert.Equal(t, "SELECT * FROM tags", top[1].Query)
+	assert.Equal(t, int64(2), top[1].Lookups)
+	assert.Equal(t, int64(1), top[1].Error)
+	assert.Equal(t, int64(1), top[1].Hits)
+	assert.Equal(t, int64(0), top[1].Misses)
+	assert.Equal(t, int64(0), top[1].Stores)
+
+	top = topQueries.Top(1, SortByBytes)
+	assert.Len(t, top, 1)
+	assert.Equal(t, "SELECT * FROM users", top[0].Query)
+
+	// Only the hits since the response was last cached make it hot.
+	hot := topQueries.Hot(2)
+	assert.Len(t, hot, 1)
+	assert.Equal(t, users, hot[0].cacheKey)
+	topQueries.RecordStore(users, time.Minute)
+	assert.Empty(t, topQueries.Hot(2))
+	topQueries.RecordLookup(users, "", true, 100)
+	topQueries.RecordLookup(users, "", true, 100)
+	hot = topQueries.Hot(2)
+	assert.Len(t, hot, 1)
+	assert.Equal(t, int64(2), hot[0].RecentHits)
+	assert.Equal(t, int64(4), hot[0].Hits)
+	assert.Equal(t, time.Minute, hot[0].expiry)
+
+	// A nil tracker records nothing.
+	var disabled *TopQueries
+	disabled.RecordLookup(users, "", true, 1)
+	disabled.RecordStore(users, time.Hour)
+}
+
+func TestTopQueriesHandlerAndMetrics(t *testing.T) {
+	plugin, _ := newTestPlugin(t)
+	p := &plugin.Impl
+	handler := p.AdminHandler()
+
+	serve := func(target string) (int, map[string]any) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		var body map[string]any
+		assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+		return recorder.Code, body
+	}
+
+	status, _ := serve("/top-queries")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	p.TopQueries = NewTopQueries(DefaultTopQueriesCapacity)
+	users := testCacheKey(t, p, "SELECT * FROM users")
+	posts := testCacheKey(t, p, "SELECT * FROM posts")
+	p.TopQueries.RecordLookup(users, "", true, 10)
+	p.TopQueries.RecordLookup(posts, "", false, 0)
+	p.TopQueries.RecordLookup(posts, "", false, 0)
+
+	status, body := serve("/top-queries?limit=1&sort=hits")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, body["queries"], 1)
+	if queries, ok := body["queries"].([]any); ok && len(queries) == 1 {
+		assert.Equal(t, "SELECT * FROM users", queries[0].(map[string]any)["query"])
+	}
+
+	status, _ = serve("/top-queries?sort=size")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = serve("/top-queries?limit=-1")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// Only the top queries by lookups are exported.
+	p.sampleTopQueries(1)
+	postsFingerprint, err := fingerprintQuery("SELECT * FROM posts")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, testutil.CollectAndCount(TopQueryMissesGauge))
+	assert.InDelta(t, 2, testutil.ToFloat64(
+		TopQueryMissesGauge.WithLabelValues("postgres", postsFingerprint)), 0)
+	assert.InDelta(t, 0, testutil.ToFloat64(
+		TopQueryHitsGauge.WithLabelValues("postgres", postsFingerprint)), 0)
+}
@@ -0,0 +1,224 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+
+	sdkPlugin "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin"
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+	goRedis "github.com/redis/go-redis/v9"
+	"github.com/spf13/cast"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdkTrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// TraceContextField is the field of the server responses queued for caching that
+	// carries the trace context of OnTrafficFromServer to the cache writers.
+	TraceContextField = "traceContext"
+
+	// Span attributes.
+	CacheFingerprintAttribute = attribute.Key("cache.fingerprint")
+	CacheHitAttribute         = attribute.Key("cache.hit")
+	CacheTablesAttribute      = attribute.Key("cache.tables")
+	CacheTagsAttribute        = attribute.Key("cache.tags")
+	CacheTTLAttribute         = attribute.Key("cache.ttl")
+	CacheDeletedAttribute     = attribute.Key("cache.deleted")
+)
+
+// tracePropagator propagates the W3C trace context, both from the gRPC metadata
+// of the hooks and through the server responses queued for caching.
+var tracePropagator = propagation.TraceContext{}
+
+// NewTracerProvider returns a tracer provider that exports the spans via OTLP over
+// gRPC to the endpoint, e.g. an OpenTelemetry collector. A fraction of the traces
+// started by the plugin is sampled, while traces started by GatewayD are sampled
+// if GatewayD sampled them. The provider must be shut down to flush the spans.
+func NewTracerProvider(
+	ctx context.Context, endpoint string, insecure bool, sampleRatio float64,
+) (*sdkTrace.TracerProvider, error) {
+	options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if insecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return sdkTrace.NewTracerProvider(
+		sdkTrace.WithBatcher(exporter),
+		sdkTrace.WithSampler(sdkTrace.ParentBased(sdkTrace.TraceIDRatioBased(sampleRatio))),
+		sdkTrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(PluginID.GetName()),
+			semconv.ServiceVersion(PluginID.GetVersion()),
+		)),
+	), nil
+}
+
+// tracer returns the tracer of the plugin, which doesn't record spans if tracing is disabled.
+func (p *Plugin) tracer() trace.Tracer {
+	if p.Tracer == nil {
+		return noop.NewTracerProvider().Tracer(PluginID.GetName())
+	}
+	return p.Tracer
+}
+
+// startSpan starts a span. If the context has no span, the span continues
+// the trace propagated by GatewayD in the gRPC metadata of the hook, if any.
+func (p *Plugin) startSpan(
+	ctx context.Context, name string, attributes ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	return p.tracer().Start(incomingTraceContext(ctx), name, trace.WithAttributes(attributes...))
+}
+
+// incomingTraceContext returns the context with the trace context propagated by
+// GatewayD in the gRPC metadata of the hook, unless the context already has a span.
+func incomingTraceContext(ctx context.Context) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		return tracePropagator.Extract(ctx, metadataCarrier(md))
+	}
+	return ctx
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// injectTraceContext adds the trace context of the span in ctx to the server response,
+// so that the response is cached in the same trace.
+func injectTraceContext(ctx context.Context, resp *v1.Struct) {
+	carrier := propagation.MapCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return
+	}
+
+	traceContext := make(map[string]any, len(carrier))
+	for key, value := range carrier {
+		traceContext[key] = value
+	}
+	if value, err := v1.NewValue(traceContext); err == nil {
+		resp.Fields[TraceContextField] = value
+	}
+}
+
+// extractSpanContext returns the span context added to the server response by injectTraceContext.
+func extractSpanContext(resp *v1.Struct) trace.SpanContext {
+	carrier := propagation.MapCarrier(
+		cast.ToStringMapString(sdkPlugin.GetAttr(resp, TraceContextField, nil)))
+	return trace.SpanContextFromContext(tracePropagator.Extract(context.Background(), carrier))
+}
+
+// setSpanFingerprint sets the fingerprint of the query of the cache key on the span.
+// Fingerprinting parses the query, so it is skipped if the span is not recording.
+func setSpanFingerprint(span trace.Span, cacheKey string) {
+	if !span.IsRecording() {
+		return
+	}
+
+	_, _, request, _ := parseCacheKey(cacheKey)
+	span.SetAttributes(CacheFingerprintAttribute.String(requestFingerprint(request)))
+}
+
+// metadataCarrier adapts the gRPC metadata for the trace propagator.
+type metadataCarrier metadata.MD
+
+var _ propagation.TextMapCarrier = metadataCarrier{}
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// RedisTracingHook creates a span for each Redis command and pipeline sent
+// with a context that has a span.
+type RedisTracingHook struct {
+	Tracer trace.Tracer
+}
+
+var _ goRedis.Hook = RedisTracingHook{}
+
+func (h RedisTracingHook) DialHook(next goRedis.DialHook) goRedis.DialHook {
+	return next
+}
+
+func (h RedisTracingHook) ProcessHook(next goRedis.ProcessHook) goRedis.ProcessHook {
+	return func(ctx context.Context, cmd goRedis.Cmder) error {
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			return next(ctx, cmd)
+		}
+
+		ctx, span := h.Tracer.Start(ctx, "redis."+cmd.Name(),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemNameRedis, semconv.DBOperationName(cmd.Name())))
+		err := next(ctx, cmd)
+		endSpan(span, redisSpanError(err))
+		return err
+	}
+}
+
+func (h RedisTracingHook) ProcessPipelineHook(next goRedis.ProcessPipelineHook) goRedis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []goRedis.Cmder) error {
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			return next(ctx, cmds)
+		}
+
+		names := make([]string, 0, len(cmds))
+		for _, cmd := range cmds {
+			names = append(names, cmd.Name())
+		}
+
+		ctx, span := h.Tracer.Start(ctx, "redis."+PipelineCommand,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemNameRedis,
+				semconv.DBOperationName(PipelineCommand),
+				semconv.DBOperationBatchSize(len(cmds)),
+				attribute.StringSlice("db.redis.commands", names),
+			))
+		err := next(ctx, cmds)
+		endSpan(span, redisSpanError(err))
+		return err
+	}
+}
+
+// redisSpanError returns the error to record on a Redis span. Missing keys are not errors.
+func redisSpanError(err error) error {
+	if errors.Is(err, goRedis.Nil) {
+		return nil
+	}
+	return err
+}
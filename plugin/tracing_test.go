@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdkTrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc/metadata"
+)
+
+const testTraceParent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+// spanAttribute returns the value of the attribute of the span.
+func spanAttribute(span sdkTrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// endedSpans returns the spans ended by the plugin, by name.
+func endedSpans(recorder *tracetest.SpanRecorder) map[string]sdkTrace.ReadOnlySpan {
+	spans := map[string]sdkTrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	return spans
+}
+
+func TestTracing(t *testing.T) {
+	p, redisClient := newTestPlugin(t)
+	p.Impl.DefaultDBName = "postgres"
+
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdkTrace.NewTracerProvider(sdkTrace.WithSpanProcessor(recorder))
+	p.Impl.Tracer = tracerProvider.Tracer(PluginID.GetName())
+	redisClient.AddHook(RedisTracingHook{Tracer: p.Impl.Tracer})
+
+	// GatewayD propagates the trace context in the gRPC metadata of the hooks.
+	ctx := metadata.NewIncomingContext(
+		context.Background(), metadata.Pairs("traceparent", testTraceParent))
+
+	p.Impl.WaitGroup.Add(1)
+	go p.Impl.UpdateCache(context.Background())
+
+	_, request := testQueryRequest()
+	response, _ := base64.StdEncoding.DecodeString(
+		"VAAAABsAAWlkAAAAQAQAAQAAABcABP////8AAEQAAAALAAEAAAABMUMAAAANU0VMRUNUIDEAWgAAAAVJ")
+	args := map[string]interface{}{
+		"request": request,
+		"server": map[string]interface{}{
+			"remote": "localhost:5432",
+		},
+	}
+	req, err := v1.NewStruct(args)
+	assert.Nil(t, err)
+	_, err = p.Impl.OnTrafficFromClient(ctx, req)
+	assert.Nil(t, err)
+
+	args["response"] = response
+	resp, err := v1.NewStruct(args)
+	assert.Nil(t, err)
+	_, err = p.Impl.OnTrafficFromServer(ctx, resp)
+	assert.Nil(t, err)
+
+	close(p.Impl.UpdateCacheChannel)
+	p.Impl.WaitGroup.Wait()
+
+	_, err = p.Impl.OnTrafficFromClient(ctx, req)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, p.Impl.InvalidateTable(context.Background(), "users"))
+
+	spans := endedSpans(recorder)
+
+	// The lookup continues the trace of GatewayD, and is a hit the second time.
+	lookup := spans["cache.lookup"]
+	assert.NotNil(t, lookup)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", lookup.Parent().TraceID().String())
+	assert.True(t, lookup.Parent().IsRemote())
+	assert.True(t, spanAttribute(lookup, CacheHitAttribute).AsBool())
+	assert.Equal(t, []string{"users"}, spanAttribute(lookup, CacheTablesAttribute).AsStringSlice())
+	assert.Len(t, spanAttribute(lookup, CacheFingerprintAttribute).AsString(), FingerprintLength)
+	assert.NotEmpty(t, spanAttribute(lookup, CacheTTLAttribute).AsString())
+
+	// The response is stored in the same trace, by the cache writer.
+	store := spans["cache.store"]
+	assert.NotNil(t, store)
+	assert.Equal(t, lookup.SpanContext().TraceID(), store.SpanContext().TraceID())
+	assert.Equal(t, "1h0m0s", spanAttribute(store, CacheTTLAttribute).AsString())
+
+	// The Redis commands are children of the spans that sent them.
+	get := spans["redis.get"]
+	assert.NotNil(t, get)
+	assert.Equal(t, lookup.SpanContext().TraceID(), get.SpanContext().TraceID())
+
+	invalidate := spans["cache.invalidate"]
+	assert.NotNil(t, invalidate)
+	assert.Equal(t, int64(2), spanAttribute(invalidate, CacheDeletedAttribute).AsInt64())
+}
+
+func TestTracingDisabled(t *testing.T) {
+	p, _ := newTestPlugin(t)
+	_, span := p.Impl.startSpan(context.Background(), "cache.lookup")
+	assert.False(t, span.IsRecording())
+	span.End()
+}
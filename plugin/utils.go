@@ -6,8 +6,6 @@ import (
 	"strings"
 )
 
-const AddressPortPairLength = 2
-
 // validateIP checks if an IP address is valid.
 func validateIP(ip net.IP) bool {
 	if ip == nil {
@@ -21,58 +19,56 @@ func validateIP(ip net.IP) bool {
 	return false
 }
 
-// validateAddressPort validates an address:port string.
-func validateAddressPort(addressPort string) (bool, error) {
-	// Split the address and port.
-	// TODO: Support IPv6.
-	data := strings.Split(strings.TrimSpace(addressPort), ":")
-	if len(data) != AddressPortPairLength {
-		return false, ErrInvalidAddressPortPair
+// splitHostPort splits a host:port or [host]:port string and validates the port.
+func splitHostPort(hostPort string) (string, bool, error) {
+	host, portString, err := net.SplitHostPort(strings.TrimSpace(hostPort))
+	if err != nil {
+		return "", false, ErrInvalidAddressPortPair
 	}
 
-	// Validate the port.
-	port, err := strconv.ParseUint(data[1], 10, 16)
+	port, err := strconv.ParseUint(portString, 10, 16)
 	if err != nil {
-		return false, err
+		return "", false, err
 	}
 
-	// Resolve the IP address, if it is a host.
-	ipAddress, err := net.ResolveIPAddr("ip", data[0])
+	return host, port > 0 && port <= 65535, nil
+}
+
+// validateAddressPort validates an address:port string. IPv6 addresses must be
+// enclosed in square brackets, e.g. [::1]:5432.
+func validateAddressPort(addressPort string) (bool, error) {
+	host, validPort, err := splitHostPort(addressPort)
 	if err != nil {
 		return false, err
 	}
 
-	// Validate the IP address and port.
-	if (validateIP(net.ParseIP(data[0])) || validateIP(ipAddress.IP)) && (port > 0 && port <= 65535) {
-		return true, nil
+	// Validate the IP address, or resolve it, if it is a host.
+	if validateIP(net.ParseIP(host)) {
+		return validPort, nil
+	}
+
+	ipAddress, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return false, err
 	}
 
-	return false, nil
+	return validateIP(ipAddress.IP) && validPort, nil
 }
 
 // validateHostPort validates a host:port string.
-// TODO: Add support for IPv6.
 func validateHostPort(hostPort string) (bool, error) {
-	data := strings.Split(hostPort, ":")
-	if len(data) != AddressPortPairLength {
-		return false, ErrInvalidAddressPortPair
-	}
-
-	port, err := strconv.ParseUint(data[1], 10, 16)
+	host, validPort, err := splitHostPort(hostPort)
 	if err != nil {
 		return false, err
 	}
 
 	// FIXME: There is not much to validate on the host side.
-	if data[0] != "" && port > 0 && port <= 65535 {
-		return true, nil
-	}
-
-	return false, nil
+	return host != "" && validPort, nil
 }
 
 // isBusy checks if a client address exists in cache by matching the address
-// with the busy clients.
+// with the busy clients. Addresses are compared by their session ID, so that
+// e.g. IPv6 addresses match regardless of how they are written.
 func isBusy(proxies map[string]map[string]Proxy, address string) bool {
 	if proxies == nil {
 		// NOTE: If the API is not running, we assume that the client is busy,
@@ -83,7 +79,7 @@ func isBusy(proxies map[string]map[string]Proxy, address string) bool {
 	for _, group := range proxies {
 		for _, block := range group {
 			for _, client := range block.Busy {
-				if client == address {
+				if client == address || sameSession(client, address) {
 					return true
 				}
 			}
@@ -91,3 +87,14 @@ func isBusy(proxies map[string]map[string]Proxy, address string) bool {
 	}
 	return false
 }
+
+// sameSession checks if two client addresses belong to the same session.
+func sameSession(address, other string) bool {
+	sessionID, err := NewSessionID(address)
+	if err != nil {
+		return false
+	}
+
+	otherSessionID, err := NewSessionID(other)
+	return err == nil && sessionID == otherSessionID
+}
@@ -91,3 +91,39 @@ func Test_isBusy_False_EmptyMap(t *testing.T) {
 	proxies := map[string]map[string]Proxy{}
 	assert.False(t, isBusy(proxies, "localhost:54321"))
 }
+
+func Test_validateAddressPort_IPv6(t *testing.T) {
+	valid, err := validateAddressPort("[::1]:5432")
+	assert.True(t, valid)
+	assert.Nil(t, err)
+
+	valid, err = validateAddressPort("  [2001:db8::1]:5432  ")
+	assert.True(t, valid)
+	assert.Nil(t, err)
+
+	valid, err = validateAddressPort("::1:5432")
+	assert.False(t, valid)
+	assert.NotNil(t, err)
+}
+
+func Test_validateHostPort_IPv6(t *testing.T) {
+	valid, err := validateHostPort("[::1]:5432")
+	assert.True(t, valid)
+	assert.Nil(t, err)
+
+	valid, err = validateHostPort("[::1]:0")
+	assert.False(t, valid)
+	assert.Nil(t, err)
+}
+
+func Test_isBusy_IPv6(t *testing.T) {
+	proxies := map[string]map[string]Proxy{
+		"default": {
+			"reads": {
+				Busy: []string{"[0:0:0:0:0:0:0:1]:12345"},
+			},
+		},
+	}
+	assert.True(t, isBusy(proxies, "[::1]:12345"))
+	assert.False(t, isBusy(proxies, "[::1]:54321"))
+}
@@ -0,0 +1,188 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	goRedis "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MaxPendingVerifications is the number of sampled cache hits whose response
+// can be awaited at once. No more hits are sampled until some are verified or
+// expire.
+const MaxPendingVerifications = 1000
+
+// PendingVerificationTimeout is how long the response of a sampled cache hit is
+// awaited. The response might never be cached, e.g. if it is dropped because the
+// cache update channel is full, so the sampled cache hit expires after that.
+const PendingVerificationTimeout = time.Minute
+
+// Results of verifying a cached response.
+const (
+	VerificationMatch    = "match"
+	VerificationMismatch = "mismatch"
+	// VerificationMissing means that the cached response was invalidated or
+	// expired before the response of the server was received.
+	VerificationMissing = "missing"
+	// VerificationExpired means that the response of the server was not received
+	// within PendingVerificationTimeout.
+	VerificationExpired = "expired"
+)
+
+// pendingVerifications are the cache keys of the sampled cache hits, whose
+// response is awaited from the server, along with the times they were sampled.
+type pendingVerifications struct {
+	mutex sync.Mutex
+	keys  map[string][]time.Time
+	now   func() time.Time
+}
+
+func newPendingVerifications() *pendingVerifications {
+	return &pendingVerifications{keys: map[string][]time.Time{}, now: time.Now}
+}
+
+// add adds the cache key, unless too many verifications are pending.
+func (v *pendingVerifications) add(cacheKey string) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.expire()
+	if len(v.keys) >= MaxPendingVerifications {
+		return false
+	}
+	v.keys[cacheKey] = append(v.keys[cacheKey], v.now())
+	return true
+}
+
+// take removes the oldest sample of the cache key and returns whether it was
+// pending. Expired samples are not pending anymore.
+func (v *pendingVerifications) take(cacheKey string) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.expire()
+	sampled, ok := v.keys[cacheKey]
+	if !ok {
+		return false
+	}
+	if len(sampled) <= 1 {
+		delete(v.keys, cacheKey)
+	} else {
+		v.keys[cacheKey] = sampled[1:]
+	}
+	return true
+}
+
+// expire removes the samples older than PendingVerificationTimeout. The mutex
+// must be held.
+func (v *pendingVerifications) expire() {
+	deadline := v.now().Add(-PendingVerificationTimeout)
+	for cacheKey, sampled := range v.keys {
+		// Samples are in the order they were added.
+		expired := 0
+		for expired < len(sampled) && !sampled[expired].After(deadline) {
+			expired++
+		}
+		if expired == 0 {
+			continue
+		}
+
+		CacheVerificationsCounter.WithLabelValues(VerificationExpired).Add(float64(expired))
+		if expired == len(sampled) {
+			delete(v.keys, cacheKey)
+		} else {
+			v.keys[cacheKey] = sampled[expired:]
+		}
+	}
+}
+
+// sampleVerification decides whether a cache hit is let through to the server,
+// so that its response is compared with the cached one.
+func (p *Plugin) sampleVerification(cacheKey string) bool {
+	if p.VerificationSampleRate <= 0 || p.verifications == nil ||
+		rand.Float64() >= p.VerificationSampleRate { //nolint:gosec
+		return false
+	}
+
+	return p.verifications.add(cacheKey)
+}
+
+// verifyCachedResponse compares the response of the server to a sampled cache
+// hit with the cached response. A mismatch means that the cache served a stale
+// response, so it is reported and the cached response is refreshed. It returns
+// whether the cached response matches, in which case it is kept as is.
+func (p *Plugin) verifyCachedResponse(ctx context.Context, write *cacheWrite) bool {
+	cached, err := p.RedisClient.Get(ctx, p.responseKey(write.cacheKey)).Bytes()
+	CacheGetsCounter.Inc()
+	if errors.Is(err, goRedis.Nil) {
+		CacheVerificationsCounter.WithLabelValues(VerificationMissing).Inc()
+		return false
+	} else if err != nil {
+		CacheErrorsCounter.Inc()
+		p.Logger.Debug("Failed to get cached response", "error", err)
+		return false
+	}
+
+	if responsesMatch(cached, write.response, write.query) {
+		CacheVerificationsCounter.WithLabelValues(VerificationMatch).Inc()
+		return true
+	}
+
+	_, database, request, _ := parseCacheKey(write.cacheKey)
+	fingerprint := requestFingerprint(request)
+	CacheVerificationsCounter.WithLabelValues(VerificationMismatch).Inc()
+	p.countPerTable(CacheVerificationMismatchesCounter, database, write.tables)
+	trace.SpanFromContext(ctx).AddEvent("stale response", trace.WithAttributes(
+		CacheFingerprintAttribute.String(fingerprint),
+		CacheTablesAttribute.StringSlice(write.tables)))
+	p.Logger.Warn("The cache served a stale response, refreshing it",
+		"database", database, "fingerprint", fingerprint, "tables", write.tables,
+		"cachedBytes", len(cached), "freshBytes", len(write.response))
+
+	return false
+}
+
+// responsesMatch compares a cached response with a fresh one, byte for byte.
+// If the query has no ORDER BY, the rows may be returned in any order, so the
+// responses are also compared row-wise, ignoring the order of the rows.
+func responsesMatch(cached, fresh []byte, query string) bool {
+	if bytes.Equal(cached, fresh) {
+		return true
+	}
+
+	// TODO: This is a naive check, but query parsing has a cost.
+	if query == "" || strings.Contains(strings.ToUpper(query), "ORDER BY") {
+		return false
+	}
+
+	cachedResult, err := DecodeResponse(cached)
+	if err != nil {
+		return false
+	}
+	freshResult, err := DecodeResponse(fresh)
+	if err != nil {
+		return false
+	}
+
+	return cachedResult.Error == freshResult.Error &&
+		cachedResult.CommandComplete == freshResult.CommandComplete &&
+		slices.Equal(cachedResult.Columns, freshResult.Columns) &&
+		slices.Equal(sortedRows(cachedResult.Rows), sortedRows(freshResult.Rows))
+}
+
+// sortedRows returns the rows encoded as strings, sorted.
+func sortedRows(rows [][]string) []string {
+	sorted := make([]string, 0, len(rows))
+	for _, row := range rows {
+		sorted = append(sorted, strings.Join(row, "\x00"))
+	}
+	slices.Sort(sorted)
+	return sorted
+}
@@ -0,0 +1,172 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	sdkAct "github.com/gatewayd-io/gatewayd-plugin-sdk/act"
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// encodeResponse encodes a query response with a single text column.
+func encodeResponse(t *testing.T, rows ...string) []byte {
+	t.Helper()
+	response, err := (&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{
+		{Name: []byte("name"), DataTypeOID: 25, DataTypeSize: -1, TypeModifier: -1},
+	}}).Encode(nil)
+	assert.Nil(t, err)
+	for _, row := range rows {
+		response, err = (&pgproto3.DataRow{Values: [][]byte{[]byte(row)}}).Encode(response)
+		assert.Nil(t, err)
+	}
+	response, err = (&pgproto3.CommandComplete{CommandTag: []byte("SELECT")}).Encode(response)
+	assert.Nil(t, err)
+	return response
+}
+
+func TestResponsesMatch(t *testing.T) {
+	ab := encodeResponse(t, "a", "b")
+	ba := encodeResponse(t, "b", "a")
+	ac := encodeResponse(t, "a", "c")
+
+	assert.True(t, responsesMatch(ab, ab, "SELECT name FROM users ORDER BY name"))
+	assert.True(t, responsesMatch(ab, ba, "SELECT name FROM users"))
+	assert.False(t, responsesMatch(ab, ba, "SELECT name FROM users order by name"))
+	assert.False(t, responsesMatch(ab, ac, "SELECT name FROM users"))
+	assert.False(t, responsesMatch(ab, []byte("invalid"), "SELECT name FROM users"))
+}
+
+func TestVerification(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	p.DefaultDBName = "postgres"
+	p.VerificationSampleRate = 1
+	ctx := context.Background()
+
+	query, request := testQueryRequest()
+	cacheKey := populateCache(t, p, redisClient, "localhost:5432", "postgres", query, "users")
+	redisClient.Set(ctx, p.responseKey(cacheKey), encodeResponse(t, "a", "b"), 0)
+
+	lookup := func() *v1.Struct {
+		req, err := v1.NewStruct(map[string]interface{}{
+			"request": request,
+			"server": map[string]interface{}{
+				"remote": "localhost:5432",
+			},
+		})
+		assert.Nil(t, err)
+		result, err := p.OnTrafficFromClient(ctx, req)
+		assert.Nil(t, err)
+		return result
+	}
+
+	// The sampled cache hit is sent to the server.
+	samples := testutil.ToFloat64(CacheVerificationSamplesCounter)
+	assert.NotContains(t, lookup().GetFields(), sdkAct.Signals)
+	assert.InDelta(t, samples+1, testutil.ToFloat64(CacheVerificationSamplesCounter), 0)
+
+	// The rows in a different order match, so the cached response is kept.
+	matches := testutil.ToFloat64(CacheVerificationsCounter.WithLabelValues(VerificationMatch))
+	p.writeCache(ctx, &cacheWrite{
+		cacheKey: cacheKey, response: encodeResponse(t, "b", "a"), query: query,
+		tables: []string{"users"}, verify: p.verifications.take(cacheKey),
+	})
+	assert.InDelta(t, matches+1, testutil.ToFloat64(
+		CacheVerificationsCounter.WithLabelValues(VerificationMatch)), 0)
+	assert.Equal(t, string(encodeResponse(t, "a", "b")), redisClient.Get(ctx, p.responseKey(cacheKey)).Val())
+
+	// A stale cached response is reported and refreshed.
+	lookup()
+	mismatches := testutil.ToFloat64(CacheVerificationMismatchesCounter.WithLabelValues("postgres", "users"))
+	p.writeCache(ctx, &cacheWrite{
+		cacheKey: cacheKey, response: encodeResponse(t, "c"), query: query,
+		tables: []string{"users"}, verify: p.verifications.take(cacheKey),
+	})
+	assert.InDelta(t, mismatches+1, testutil.ToFloat64(
+		CacheVerificationMismatchesCounter.WithLabelValues("postgres", "users")), 0)
+	assert.Equal(t, string(encodeResponse(t, "c")), redisClient.Get(ctx, p.responseKey(cacheKey)).Val())
+
+	// Only the sampled cache hits are verified.
+	assert.False(t, p.verifications.take(cacheKey))
+	p.VerificationSampleRate = 0
+	assert.Contains(t, lookup().GetFields(), sdkAct.Signals)
+	assert.False(t, p.verifications.take(cacheKey))
+}
+
+func TestVerificationOfUncacheableResponse(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	p.DefaultDBName = "postgres"
+	p.VerificationSampleRate = 1
+	ctx := context.Background()
+
+	query, request := testQueryRequest()
+	cacheKey := populateCache(t, p, redisClient, "localhost:5432", "postgres", query, "users")
+	redisClient.Set(ctx, p.responseKey(cacheKey), encodeResponse(t, "a"), 0)
+	server := map[string]interface{}{
+		"remote": "localhost:5432",
+	}
+
+	req, err := v1.NewStruct(map[string]interface{}{"request": request, "server": server})
+	assert.Nil(t, err)
+	_, err = p.OnTrafficFromClient(ctx, req)
+	assert.Nil(t, err)
+
+	// The table is gone, so the server returns an error, which is not cached.
+	response, err := (&pgproto3.ErrorResponse{
+		Severity: "ERROR", Code: "42P01", Message: `relation "users" does not exist`,
+	}).Encode(nil)
+	assert.Nil(t, err)
+	serverResponse, err := v1.NewStruct(map[string]interface{}{
+		"request": request, "response": response, "server": server,
+	})
+	assert.Nil(t, err)
+	write := p.prepareCacheWrite(ctx, serverResponse)
+	assert.NotNil(t, write)
+	assert.True(t, write.verify)
+	assert.True(t, write.uncacheable)
+
+	// The stale cached response is reported and deleted.
+	mismatches := testutil.ToFloat64(CacheVerificationsCounter.WithLabelValues(VerificationMismatch))
+	p.writeCache(ctx, write)
+	assert.InDelta(t, mismatches+1, testutil.ToFloat64(
+		CacheVerificationsCounter.WithLabelValues(VerificationMismatch)), 0)
+	assert.Equal(t, int64(0), redisClient.Exists(ctx, p.responseKey(cacheKey)).Val())
+
+	// Responses that are not cached nor verified are skipped.
+	assert.Nil(t, p.prepareCacheWrite(ctx, serverResponse))
+}
+
+func TestPendingVerificationsExpire(t *testing.T) {
+	now := time.Now()
+	verifications := newPendingVerifications()
+	verifications.now = func() time.Time { return now }
+	expired := testutil.ToFloat64(CacheVerificationsCounter.WithLabelValues(VerificationExpired))
+
+	for i := range MaxPendingVerifications {
+		assert.True(t, verifications.add(fmt.Sprintf("key%d", i)))
+	}
+	// No more cache hits are sampled while too many verifications are pending.
+	assert.False(t, verifications.add("key"))
+
+	// The responses that are never received expire, so sampling resumes.
+	now = now.Add(PendingVerificationTimeout)
+	assert.True(t, verifications.add("key"))
+	assert.InDelta(t, expired+MaxPendingVerifications, testutil.ToFloat64(
+		CacheVerificationsCounter.WithLabelValues(VerificationExpired)), 0)
+	assert.False(t, verifications.take("key0"))
+
+	// The samples of the same key expire in order.
+	now = now.Add(PendingVerificationTimeout / 2)
+	assert.True(t, verifications.add("key"))
+	now = now.Add(PendingVerificationTimeout / 2)
+	assert.True(t, verifications.take("key"))
+	assert.False(t, verifications.take("key"))
+	assert.InDelta(t, expired+MaxPendingVerifications+1, testutil.ToFloat64(
+		CacheVerificationsCounter.WithLabelValues(VerificationExpired)), 0)
+}
@@ -0,0 +1,292 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd-plugin-sdk/databases/postgres"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+const (
+	// DefaultWarmupConcurrency is the number of warm-up queries run at once if none is configured.
+	DefaultWarmupConcurrency = 4
+
+	// Results of warm-up queries.
+	WarmupCached  = "cached"
+	WarmupSkipped = "skipped"
+	WarmupFailed  = "failed"
+)
+
+// WarmupEntry is a query whose response is cached by the warm-up.
+type WarmupEntry struct {
+	Database string `json:"database"`
+	User     string `json:"user"`
+	SQL      string `json:"sql"`
+}
+
+// WarmupResult is the number of warm-up queries by result.
+type WarmupResult struct {
+	Cached  int `json:"cached"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+// ReadWarmupEntries reads warm-up entries in JSON Lines format, one object with
+// the database, the user and the SQL of a query per line, e.g.
+//
+//	{"database": "postgres", "user": "postgres", "sql": "SELECT * FROM users"}
+//
+// Empty lines and lines starting with # are ignored.
+func ReadWarmupEntries(reader io.Reader) ([]WarmupEntry, error) {
+	var entries []WarmupEntry
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var entry WarmupEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil || entry.SQL == "" {
+			return nil, ErrInvalidWarmupEntry
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// WarmUp runs the queries over its own connections to the database server in
+// PostgresConfig and caches their responses under the keys OnTrafficFromClient
+// looks up, so that the cache is filled before clients send them. The database
+// and the user of the entries default to the ones of PostgresConfig. Responses
+// that OnTrafficFromServer would not cache, e.g. errors, are skipped.
+func (p *Plugin) WarmUp(ctx context.Context, entries []WarmupEntry, concurrency int) WarmupResult {
+	if concurrency <= 0 {
+		concurrency = DefaultWarmupConcurrency
+	}
+	config := p.PostgresConfig
+
+	// The responses are cached for the server as it is addressed by GatewayD,
+	// which reports the IP it is connected to.
+	server := p.postgresServer()
+	servers := p.serverAddresses(server)
+	if len(servers) == 0 {
+		p.Logger.Warn("The database server can't be resolved nor is mapped to a cluster by SERVER_GROUPS, "+
+			"skipping the warm-up", "server", server)
+		return WarmupResult{}
+	}
+	p.Logger.Info("Warming up the cache", "queries", len(entries), "servers", servers)
+	start := time.Now()
+	WarmupPendingQueriesGauge.Set(float64(len(entries)))
+
+	jobs := make(chan WarmupEntry)
+	var result WarmupResult
+	var mutex sync.Mutex
+	var workers sync.WaitGroup
+	for range concurrency {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+
+			// The connections of a worker are reused by its queries.
+			connections := map[string]*pgconn.PgConn{}
+			defer func() {
+				for _, conn := range connections {
+					conn.Close(context.Background())
+				}
+			}()
+
+			for entry := range jobs {
+				outcome := p.cacheQuery(ctx, config, servers, connections, entry)
+				WarmupQueriesCounter.WithLabelValues(outcome).Inc()
+				WarmupPendingQueriesGauge.Dec()
+
+				mutex.Lock()
+				switch outcome {
+				case WarmupCached:
+					result.Cached++
+				case WarmupSkipped:
+					result.Skipped++
+				default:
+					result.Failed++
+				}
+				done := result.Cached + result.Skipped + result.Failed
+				if done%max(len(entries)/10, 1) == 0 {
+					p.Logger.Info("Warm-up progress", "done", done, "queries", len(entries))
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, entry := range entries {
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- entry:
+		}
+	}
+	close(jobs)
+	workers.Wait()
+	WarmupPendingQueriesGauge.Set(0)
+
+	p.Logger.Info("Warmed up the cache",
+		"cached", result.Cached, "skipped", result.Skipped, "failed", result.Failed,
+		"duration", time.Since(start).String())
+	return result
+}
+
+// postgresServer returns the address of the database server in PostgresConfig.
+func (p *Plugin) postgresServer() string {
+	return net.JoinHostPort(p.PostgresConfig.Host, strconv.Itoa(int(p.PostgresConfig.Port)))
+}
+
+// cacheQuery runs a query over the connection of its database and user, creating
+// it if needed, and caches its response for each of the servers. It returns the
+// result of the query.
+func (p *Plugin) cacheQuery(
+	ctx context.Context, config *pgconn.Config, servers []string,
+	connections map[string]*pgconn.PgConn, entry WarmupEntry,
+) string {
+	if entry.Database == "" {
+		entry.Database = p.DefaultDBName
+	}
+	if entry.Database == "" {
+		entry.Database = config.Database
+	}
+	if entry.User == "" {
+		entry.User = config.User
+	}
+
+	if !IsCacheNeeded(strings.ToUpper(entry.SQL)) {
+		return WarmupSkipped
+	}
+
+	connection := entry.Database + KeySeparator + entry.User
+	conn, ok := connections[connection]
+	if !ok {
+		connConfig := config.Copy()
+		connConfig.Database = entry.Database
+		connConfig.User = entry.User
+
+		var err error
+		if conn, err = pgconn.ConnectConfig(ctx, connConfig); err != nil {
+			p.Logger.Warn("Failed to connect to the database server",
+				"database", entry.Database, "user", entry.User, "error", err)
+			return WarmupFailed
+		}
+		connections[connection] = conn
+	}
+
+	response, err := runQuery(ctx, conn, entry.SQL)
+	if err != nil {
+		// The connection is in an unknown state, so it is not reused.
+		conn.Close(context.Background())
+		delete(connections, connection)
+		p.Logger.Warn("Failed to run query", "sql", entry.SQL, "error", err)
+		return WarmupFailed
+	}
+	expiry, cacheable := p.responseExpiry(response.rowDescription, response.rows, response.errorResponse)
+	if !cacheable {
+		p.Logger.Debug("Skipping the response of the query", "sql", entry.SQL)
+		return WarmupSkipped
+	}
+
+	request, err := (&pgproto3.Query{String: entry.SQL}).Encode(nil)
+	if err != nil {
+		return WarmupFailed
+	}
+
+	tables, err := postgres.GetTablesFromQuery(entry.SQL)
+	if err != nil {
+		p.Logger.Debug("Failed to get tables from query", "error", err)
+	}
+
+	for _, server := range servers {
+		p.writeCache(ctx, &cacheWrite{
+			cacheKey: p.getCacheKey(server, entry.Database, request),
+			response: response.response,
+			query:    entry.SQL,
+			tables:   tables,
+			tags:     parseHints(entry.SQL).tags,
+			expiry:   expiry,
+		})
+	}
+	return WarmupCached
+}
+
+// queryResponse is the response of the server to a query, framed as sent by
+// the server, along with the messages it has that decide whether it is cached.
+type queryResponse struct {
+	response       []byte
+	rowDescription bool
+	rows           bool
+	errorResponse  bool
+}
+
+// runQuery sends the query as a simple query and returns the response, up to
+// and including ReadyForQuery. The response is read as framed by the server, so
+// the context is followed by the deadline of the connection, which is unusable
+// after the context is done.
+func runQuery(ctx context.Context, conn *pgconn.PgConn, sql string) (*queryResponse, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.Conn().SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+		defer conn.Conn().SetDeadline(time.Time{}) //nolint:errcheck
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.Conn().SetDeadline(time.Now()) //nolint:errcheck
+	})
+	defer stop()
+
+	response, err := readQueryResponse(conn, sql)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return response, err
+}
+
+// readQueryResponse sends the query and reads its response.
+func readQueryResponse(conn *pgconn.PgConn, sql string) (*queryResponse, error) {
+	frontend := conn.Frontend()
+	frontend.Send(&pgproto3.Query{String: sql})
+	if err := frontend.Flush(); err != nil {
+		return nil, err
+	}
+
+	response := &queryResponse{}
+	for {
+		msg, err := frontend.Receive()
+		if err != nil {
+			return nil, err
+		}
+
+		if response.response, err = msg.Encode(response.response); err != nil {
+			return nil, err
+		}
+
+		switch msg.(type) {
+		case *pgproto3.RowDescription:
+			response.rowDescription = true
+		case *pgproto3.DataRow:
+			response.rows = true
+		case *pgproto3.ErrorResponse:
+			response.errorResponse = true
+		case *pgproto3.ReadyForQuery:
+			return response, nil
+		}
+	}
+}
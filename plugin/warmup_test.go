@@ -0,0 +1,215 @@
+package plugin
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveTestPostgres accepts connections of clients that don't authenticate, and
+// responds to SELECT * FROM users with a row and to other queries with an error.
+func serveTestPostgres(t *testing.T, listener net.Listener) {
+	t.Helper()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			backend := pgproto3.NewBackend(conn, conn)
+			if _, err := backend.ReceiveStartupMessage(); err != nil {
+				return
+			}
+			backend.Send(&pgproto3.AuthenticationOk{})
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+			if err := backend.Flush(); err != nil {
+				return
+			}
+
+			for {
+				msg, err := backend.Receive()
+				if err != nil {
+					return
+				}
+				query, ok := msg.(*pgproto3.Query)
+				if !ok {
+					return
+				}
+
+				if query.String == "SELECT pg_sleep(3600)" {
+					// The query hangs until the client goes away.
+					_, _ = backend.Receive()
+					return
+				}
+
+				if query.String == "SELECT * FROM users" {
+					backend.Send(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{
+						{Name: []byte("name"), DataTypeOID: 25, DataTypeSize: -1, TypeModifier: -1},
+					}})
+					backend.Send(&pgproto3.DataRow{Values: [][]byte{[]byte("alice")}})
+					backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")})
+				} else {
+					backend.Send(&pgproto3.ErrorResponse{
+						Severity: "ERROR", Code: "42P01", Message: "relation does not exist",
+					})
+				}
+				backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+				if err := backend.Flush(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func TestReadWarmupEntries(t *testing.T) {
+	entries, err := ReadWarmupEntries(strings.NewReader(`
+# Dashboards
+{"database": "postgres", "user": "postgres", "sql": "SELECT * FROM users"}
+
+{"sql": "SELECT 1"}
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, []WarmupEntry{
+		{Database: "postgres", User: "postgres", SQL: "SELECT * FROM users"},
+		{SQL: "SELECT 1"},
+	}, entries)
+
+	_, err = ReadWarmupEntries(strings.NewReader(`{"database": "postgres"}`))
+	assert.ErrorIs(t, err, ErrInvalidWarmupEntry)
+	_, err = ReadWarmupEntries(strings.NewReader(`SELECT 1`))
+	assert.ErrorIs(t, err, ErrInvalidWarmupEntry)
+}
+
+func TestWarmUp(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	ctx := context.Background()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	go serveTestPostgres(t, listener)
+
+	p.PostgresConfig, err = pgconn.ParseConfig(
+		"postgres://postgres@" + listener.Addr().String() + "/postgres?sslmode=disable")
+	assert.Nil(t, err)
+
+	cached := testutil.ToFloat64(WarmupQueriesCounter.WithLabelValues(WarmupCached))
+	result := p.WarmUp(ctx, []WarmupEntry{
+		{SQL: "SELECT * FROM users"},
+		{Database: "other", User: "reader", SQL: "SELECT * FROM users"},
+		{SQL: "SELECT * FROM missing"},
+		{SQL: "SELECT NOW()"},
+	}, 2)
+	assert.Equal(t, WarmupResult{Cached: 2, Skipped: 2}, result)
+	assert.InDelta(t, cached+2, testutil.ToFloat64(WarmupQueriesCounter.WithLabelValues(WarmupCached)), 0)
+	assert.InDelta(t, 0, testutil.ToFloat64(WarmupPendingQueriesGauge), 0)
+
+	// The responses are cached under the keys of the queries sent via GatewayD.
+	for _, database := range []string{"postgres", "other"} {
+		request, err := (&pgproto3.Query{String: "SELECT * FROM users"}).Encode(nil)
+		assert.Nil(t, err)
+		cacheKey := p.getCacheKey(listener.Addr().String(), database, request)
+		response, err := redisClient.Get(ctx, p.responseKey(cacheKey)).Bytes()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), redisClient.Exists(ctx, p.tableIndexKey("users", cacheKey)).Val())
+
+		result, err := DecodeResponse(response)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"name"}, result.Columns)
+		assert.Equal(t, [][]string{{"alice"}}, result.Rows)
+		assert.Equal(t, "SELECT 1", result.CommandComplete)
+		assert.Equal(t, byte('Z'), response[len(response)-6])
+	}
+
+	// Queries fail if the server cannot be reached.
+	listener.Close()
+	result = p.WarmUp(ctx, []WarmupEntry{{SQL: "SELECT * FROM users"}}, 1)
+	assert.Equal(t, WarmupResult{Failed: 1}, result)
+}
+
+func TestWarmUpWithHostName(t *testing.T) {
+	plugin, redisClient := newTestPlugin(t)
+	p := &plugin.Impl
+	ctx := context.Background()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	go serveTestPostgres(t, listener)
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	assert.Nil(t, err)
+
+	stubLookupHost(t, map[string][]string{"db.test": {"127.0.0.1"}})
+	p.PostgresConfig, err = pgconn.ParseConfig(
+		"postgres://postgres@db.test:" + port + "/postgres?sslmode=disable")
+	assert.Nil(t, err)
+	p.PostgresConfig.LookupFunc = func(_ context.Context, host string) ([]string, error) {
+		return lookupHost(host)
+	}
+
+	// The response is cached for the IP GatewayD reports, not for the host name.
+	request, err := (&pgproto3.Query{String: "SELECT * FROM users"}).Encode(nil)
+	assert.Nil(t, err)
+	result := p.WarmUp(ctx, []WarmupEntry{{SQL: "SELECT * FROM users"}}, 1)
+	assert.Equal(t, WarmupResult{Cached: 1}, result)
+	assert.Equal(t, int64(1), redisClient.Exists(ctx,
+		p.responseKey(p.getCacheKey(listener.Addr().String(), "postgres", request))).Val())
+	assert.Equal(t, int64(0), redisClient.Exists(ctx,
+		p.responseKey(p.getCacheKey("db.test:"+port, "postgres", request))).Val())
+
+	// A host name that is neither resolved nor mapped to a cluster is skipped.
+	p.PostgresConfig.Host = "other.test"
+	assert.Equal(t, WarmupResult{}, p.WarmUp(ctx, []WarmupEntry{{SQL: "SELECT * FROM users"}}, 1))
+
+	// A host name mapped to a cluster is cached for the cluster.
+	p.PostgresConfig.Host = "db.test"
+	p.ServerGroups = map[string]string{"db.test:" + port: "main"}
+	stubLookupHost(t, nil)
+	p.PostgresConfig.LookupFunc = func(context.Context, string) ([]string, error) {
+		return []string{"127.0.0.1"}, nil
+	}
+	result = p.WarmUp(ctx, []WarmupEntry{{SQL: "SELECT * FROM users"}}, 1)
+	assert.Equal(t, WarmupResult{Cached: 1}, result)
+	assert.Equal(t, int64(1), redisClient.Exists(ctx,
+		p.responseKey("{main}:postgres:"+string(request))).Val())
+}
+
+func TestWarmUpStopsOnCancel(t *testing.T) {
+	plugin, _ := newTestPlugin(t)
+	p := &plugin.Impl
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	go serveTestPostgres(t, listener)
+
+	p.PostgresConfig, err = pgconn.ParseConfig(
+		"postgres://postgres@" + listener.Addr().String() + "/postgres?sslmode=disable")
+	assert.Nil(t, err)
+
+	// A hung query doesn't block its worker once the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan WarmupResult)
+	go func() {
+		done <- p.WarmUp(ctx, []WarmupEntry{{SQL: "SELECT pg_sleep(3600)"}}, 1)
+	}()
+
+	select {
+	case result := <-done:
+		assert.Equal(t, WarmupResult{Failed: 1}, result)
+	case <-time.After(5 * time.Second):
+		t.Fatal("WarmUp did not return after the context was done")
+	}
+}
@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultCacheWriters is the number of cache writers if none is configured.
+const DefaultCacheWriters = 4
+
+// cacheWrite is a response to be cached, along with the tables it depends on
+// and the tags it is invalidated with.
+type cacheWrite struct {
+	cacheKey string
+	response []byte
+	query    string
+	tables   []string
+	tags     []string
+	// expiry is the TTL of the response, which is Expiry if zero.
+	expiry time.Duration
+	// verify is set if the response is of a cache hit sampled for verification.
+	verify bool
+	// uncacheable is set if the response is not cached, e.g. an error. It is only
+	// compared with the cached response, which is deleted if it was verified and
+	// they don't match.
+	uncacheable bool
+	// spanContext is the span of the request, if it was traced.
+	spanContext trace.SpanContext
+}
+
+// startCacheWriters starts the cache writers and returns their channels. Each
+// cache key is always written by the same writer, so writes of the same key
+// are applied in order.
+func (p *Plugin) startCacheWriters(ctx context.Context) ([]chan *cacheWrite, *sync.WaitGroup) {
+	count := p.CacheWriters
+	if count <= 0 {
+		count = DefaultCacheWriters
+	}
+
+	writers := make([]chan *cacheWrite, count)
+	done := &sync.WaitGroup{}
+	for i := range writers {
+		writers[i] = make(chan *cacheWrite, cap(p.UpdateCacheChannel)/count+1)
+		done.Add(1)
+		go func(writes <-chan *cacheWrite) {
+			defer done.Done()
+			for write := range writes {
+				p.writeCache(ctx, write)
+			}
+		}(writers[i])
+	}
+
+	return writers, done
+}
+
+// cacheWriterShard returns the index of the writer of the cache key.
+func cacheWriterShard(cacheKey string, writers int) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(cacheKey))
+	return int(hash.Sum32() % uint32(writers)) //nolint:gosec
+}
+
+// writeCache writes the response and its table index keys in a single transaction,
+// so that a response is never cached without the index keys that invalidate it.
+// The keys share the hash tag of the cluster, so this also works on Redis Cluster.
+func (p *Plugin) writeCache(ctx context.Context, write *cacheWrite) {
+	defer prometheus.NewTimer(HookDurationHistogram.WithLabelValues(CacheWriteHook)).ObserveDuration()
+
+	expiry := write.expiry
+	if expiry == 0 {
+		expiry = p.Expiry
+	}
+
+	_, database, _, _ := parseCacheKey(write.cacheKey)
+	ctx, span := p.startSpan(
+		trace.ContextWithRemoteSpanContext(ctx, write.spanContext), "cache.store",
+		semconv.DBNamespace(database),
+		CacheTablesAttribute.StringSlice(write.tables),
+		CacheTagsAttribute.StringSlice(write.tags),
+		CacheTTLAttribute.String(expiry.String()))
+	setSpanFingerprint(span, write.cacheKey)
+
+	if (write.verify && p.verifyCachedResponse(ctx, write)) ||
+		(p.ShadowMode && p.compareShadowResponse(ctx, write)) {
+		span.End()
+		return
+	}
+
+	if write.uncacheable {
+		if !write.verify {
+			span.End()
+			return
+		}
+
+		// The cached response of the sampled cache hit is stale.
+		err := p.RedisClient.Del(ctx, p.responseKey(write.cacheKey)).Err()
+		if err != nil {
+			CacheErrorsCounter.Inc()
+			p.Logger.Debug("Failed to delete cached response", "error", err)
+		} else {
+			CacheDeletesCounter.Inc()
+		}
+		endSpan(span, err)
+		return
+	}
+
+	pipeline := p.RedisClient.TxPipeline()
+	pipeline.Set(ctx, p.responseKey(write.cacheKey), write.response, expiry)
+	for _, table := range write.tables {
+		pipeline.Set(ctx, p.tableIndexKey(table, write.cacheKey), "", expiry)
+	}
+	for _, tag := range write.tags {
+		pipeline.Set(ctx, p.tagIndexKey(tag, write.cacheKey), "", expiry)
+	}
+
+	cmds, err := pipeline.Exec(ctx)
+	for _, cmd := range cmds {
+		if cmd.Err() != nil {
+			CacheErrorsCounter.Inc()
+			continue
+		}
+		CacheSetsCounter.Inc()
+	}
+	endSpan(span, err)
+	if err != nil {
+		p.Logger.Debug("Failed to set cache", "error", err)
+		return
+	}
+
+	p.countPerTable(CacheTableSetsCounter, database, write.tables)
+	p.TopQueries.RecordStore(write.cacheKey, expiry)
+}
@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"testing"
+
+	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheWriterShard(t *testing.T) {
+	shard := cacheWriterShard("{localhost:5432}:postgres:SELECT 1", 8)
+	assert.GreaterOrEqual(t, shard, 0)
+	assert.Less(t, shard, 8)
+	// The same key is always written by the same writer.
+	assert.Equal(t, shard, cacheWriterShard("{localhost:5432}:postgres:SELECT 1", 8))
+	assert.Equal(t, 0, cacheWriterShard("{localhost:5432}:postgres:SELECT 1", 1))
+}
+
+func TestUpdateCacheWithMultipleWriters(t *testing.T) {
+	p, redisClient := newTestPlugin(t)
+	p.Impl.CacheWriters = 4
+	ctx := context.Background()
+
+	p.Impl.WaitGroup.Add(1)
+	go p.Impl.UpdateCache(ctx)
+
+	redisClient.Set(ctx, "gwc:v1:session:tcp:localhost:45320", "postgres", 0)
+
+	response, _ := base64.StdEncoding.DecodeString(
+		"VAAAABsAAWlkAAAAQAQAAQAAABcABP////8AAEQAAAALAAEAAAABMUMAAAANU0VMRUNUIDEAWgAAAAVJ")
+	cacheKeys := make([]string, 0, 20)
+	for i := range 20 {
+		queryMsg := pgproto3.Query{String: "SELECT * FROM users WHERE id = " + strconv.Itoa(i)}
+		request, _ := queryMsg.Encode(nil)
+		args := map[string]interface{}{
+			"request":  request,
+			"response": response,
+			"client": map[string]interface{}{
+				"remote": "localhost:45320",
+			},
+			"server": map[string]interface{}{
+				"remote": "localhost:5432",
+			},
+		}
+		resp, _ := v1.NewStruct(args)
+		p.Impl.UpdateCacheChannel <- resp
+		cacheKeys = append(cacheKeys, p.Impl.getCacheKey("localhost:5432", "postgres", request))
+	}
+
+	// Closing the channel waits for all the writers to finish.
+	close(p.Impl.UpdateCacheChannel)
+	p.Impl.WaitGroup.Wait()
+
+	for _, cacheKey := range cacheKeys {
+		assert.Equal(t, int64(2), redisClient.Exists(
+			ctx, p.Impl.responseKey(cacheKey), p.Impl.tableIndexKey("users", cacheKey)).Val())
+	}
+}
+
+// commandRecorder records the names of the commands sent in pipelines.
+type commandRecorder struct {
+	commands []string
+}
+
+func (r *commandRecorder) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (r *commandRecorder) ProcessHook(next redis.ProcessHook) redis.ProcessHook { return next }
+
+func (r *commandRecorder) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			r.commands = append(r.commands, cmd.Name())
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func TestWriteCacheIsAtomic(t *testing.T) {
+	p, redisClient := newTestPlugin(t)
+	ctx := context.Background()
+	// Open the connection first, so its handshake is not recorded.
+	redisClient.Ping(ctx)
+	recorder := &commandRecorder{}
+	redisClient.AddHook(recorder)
+
+	cacheKey := "{localhost:5432}:postgres:SELECT * FROM users JOIN posts"
+	p.Impl.writeCache(ctx, &cacheWrite{
+		cacheKey: cacheKey,
+		response: []byte("response"),
+		tables:   []string{"users", "posts"},
+	})
+
+	// The response and its index keys are written in a single transaction.
+	assert.Equal(t, []string{"multi", "set", "set", "set", "exec"}, recorder.commands)
+	assert.Equal(t, int64(3), redisClient.Exists(ctx,
+		p.Impl.responseKey(cacheKey),
+		p.Impl.tableIndexKey("users", cacheKey),
+		p.Impl.tableIndexKey("posts", cacheKey)).Val())
+
+	// Invalidation deletes the response and its index key in a transaction too.
+	recorder.commands = nil
+	assert.Equal(t, 2, p.Impl.InvalidateTable(ctx, "users"))
+	assert.Equal(t, []string{"multi", "del", "del", "exec"}, recorder.commands)
+	assert.Equal(t, int64(0), redisClient.Exists(ctx, p.Impl.responseKey(cacheKey)).Val())
+}